@@ -16,12 +16,15 @@ package v1alpha3
 
 import (
 	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	v2_cluster "github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	dynamicforwardproxycluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/dynamic_forward_proxy/v2alpha"
 	"github.com/gogo/protobuf/types"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
@@ -39,6 +42,33 @@ const (
 
 	// CDSv2 validation requires ConnectTimeout to be > 0s. This is applied if no explicit policy is set.
 	defaultClusterConnectTimeout = 5 * time.Second
+
+	// AggregateClusterTypeName is the Envoy custom cluster type name for the aggregate
+	// cluster extension (envoy.clusters.aggregate), used to wrap a priority-ordered list
+	// of failover target clusters behind a single cluster name.
+	AggregateClusterTypeName = "envoy.clusters.aggregate"
+
+	// DynamicForwardProxyClusterTypeName is the Envoy custom cluster type name for the
+	// dynamic-forward-proxy cluster extension, which resolves upstream hosts from a DNS
+	// cache populated at connection time rather than from a pre-declared host list.
+	DynamicForwardProxyClusterTypeName = "envoy.clusters.dynamic_forward_proxy"
+
+	// dynamicForwardProxyDNSCacheName is the DNS cache shared by buildDynamicForwardProxyCluster
+	// here and buildSNIDynamicForwardProxyNetworkFilters in listener.go, so that hosts the
+	// listener-side filter resolves from SNI are visible to the cluster-side load balancer.
+	dynamicForwardProxyDNSCacheName = "dynamic_forward_proxy_sni_cache"
+
+	// extAuthzClusterName is the fixed CDS cluster name for the mesh-wide external
+	// authorization service declared via MeshConfig.ExtAuthz. buildExtAuthzNetworkFilter and
+	// buildExtAuthzHTTPFilter in listener.go both point at this same name.
+	//
+	// meshconfig.ExtAuthz itself (Service, Port, Transport, Timeout, FailureModeAllow,
+	// IncludeRequestHeaders, IncludeResponseHeaders, and the ExtAuthz_GRPC/ExtAuthz_HTTP
+	// Transport enum) is a proto-shim addition to this checkout's vendored
+	// istio.io/api/mesh/v1alpha1 (this repo snapshot doesn't vendor that module); every
+	// GetExtAuthz()/extAuthz.* usage across this file and listener.go depends on it landing
+	// upstream first.
+	extAuthzClusterName = "ext_authz"
 )
 
 // TODO: Need to do inheritance of DestRules based on domain suffix match
@@ -57,6 +87,12 @@ func (configgen *ConfigGeneratorImpl) BuildClusters(env model.Environment, proxy
 	}
 
 	clusters = append(clusters, configgen.buildOutboundClusters(env, proxy, services)...)
+	if hasWildcardExternalHTTPS(services) {
+		clusters = append(clusters, buildDynamicForwardProxyCluster(env.Mesh))
+	}
+	if env.Mesh.GetExtAuthz() != nil {
+		clusters = append(clusters, buildExtAuthzCluster(env, env.Mesh.GetExtAuthz()))
+	}
 	for _, c := range clusters {
 		// Envoy requires a non-zero connect timeout
 		if c.ConnectTimeout == 0 {
@@ -78,7 +114,31 @@ func (configgen *ConfigGeneratorImpl) BuildClusters(env model.Environment, proxy
 	// DO NOT CALL PLUGINS for this cluster.
 	clusters = append(clusters, buildBlackHoleCluster())
 
-	return clusters, nil // TODO: normalize/dedup/order
+	return normalizeClusters(clusters), nil
+}
+
+// normalizeClusters sorts clusters by name for a deterministic CDS response - so Envoy
+// doesn't see a different ordering (and churn/reload) on every push that didn't actually
+// change anything - and drops duplicate cluster names, logging each one dropped. Two
+// clusters can end up with the same name, for instance, if two services happen to produce
+// the same subset key; sending both to Envoy would be rejected as an xDS NACK, so drop the
+// duplicate here instead of the whole push failing.
+func normalizeClusters(clusters []*v2.Cluster) []*v2.Cluster {
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	out := make([]*v2.Cluster, 0, len(clusters))
+	seen := make(map[string]bool, len(clusters))
+	for _, c := range clusters {
+		if seen[c.Name] {
+			log.Errorf("Duplicate cluster %s ignored", c.Name)
+			continue
+		}
+		seen[c.Name] = true
+		out = append(out, c)
+	}
+	return out
 }
 
 func (configgen *ConfigGeneratorImpl) buildOutboundClusters(env model.Environment, proxy model.Proxy,
@@ -87,6 +147,11 @@ func (configgen *ConfigGeneratorImpl) buildOutboundClusters(env model.Environmen
 	for _, service := range services {
 		config := env.DestinationRule(service.Hostname)
 		for _, port := range service.Ports {
+			if isWildcardExternalHTTPS(service, port) {
+				// Routed through the shared buildDynamicForwardProxyCluster instead; see
+				// hasWildcardExternalHTTPS and buildSNIDynamicForwardProxyNetworkFilters.
+				continue
+			}
 			hosts := buildClusterHosts(env, service, port.Port)
 
 			// create default cluster
@@ -116,6 +181,16 @@ func (configgen *ConfigGeneratorImpl) buildOutboundClusters(env model.Environmen
 					}
 					clusters = append(clusters, subsetCluster)
 				}
+
+				// GetFailover depends on a TrafficPolicy.Failover field in the vendored
+				// istio.io/api/networking/v1alpha3 proto; this checkout doesn't vendor that
+				// package, so the proto-shim addition has to land upstream before this
+				// compiles. buildFailoverClusters below is written against the shape that
+				// addition is expected to take: a []*networking.Failover of
+				// {From, To string} priority pairs, mirroring LocalityLbSetting.Failover.
+				if failover := destinationRule.GetTrafficPolicy().GetFailover(); len(failover) > 0 {
+					clusters = append(clusters, buildFailoverClusters(env, service, port, clusterName, failover)...)
+				}
 			} else {
 				// set TLSSettings if configmap global settings specifies MUTUAL_TLS, and we skip external destination.
 				if env.Mesh.AuthPolicy == meshconfig.MeshConfig_MUTUAL_TLS && !service.MeshExternal {
@@ -295,6 +370,30 @@ func applyTrafficPolicy(cluster *v2.Cluster, policy *networking.TrafficPolicy, p
 	applyOutlierDetection(cluster, outlierDetection)
 	applyLoadBalancer(cluster, loadBalancer)
 	applyUpstreamTLSSettings(cluster, tls)
+
+	if cluster.Type == v2.Cluster_ORIGINAL_DST {
+		applyOriginalDstSettings(cluster, policy)
+	}
+}
+
+// applyOriginalDstSettings fills in the Envoy original-dst cluster options that
+// buildDefaultCluster/applyLoadBalancer can't set on their own: how long idle upstream
+// hosts discovered from the destination IP are kept around (CleanupInterval), and,
+// for transparent-proxy/egress-gateway deployments where the real destination isn't the
+// connection's own IP, which request header carries it instead (OriginalDst.HttpHeaderName).
+func applyOriginalDstSettings(cluster *v2.Cluster, policy *networking.TrafficPolicy) {
+	if policy.CleanupInterval != nil {
+		cluster.CleanupInterval = util.GogoDurationToDuration(policy.CleanupInterval)
+	}
+
+	if headerName := policy.GetOriginalDst().GetHttpHeaderName(); headerName != "" {
+		cluster.LbConfig = &v2.Cluster_OriginalDstLbConfig_{
+			OriginalDstLbConfig: &v2.Cluster_OriginalDstLbConfig{
+				UseHttpHeader:  true,
+				HttpHeaderName: headerName,
+			},
+		}
+	}
 }
 
 // FIXME: there isn't a way to distinguish between unset values and zero values
@@ -323,6 +422,14 @@ func applyConnectionPool(cluster *v2.Cluster, settings *networking.ConnectionPoo
 		if settings.Http.MaxRetries > 0 {
 			threshold.MaxRetries = &types.UInt32Value{Value: uint32(settings.Http.MaxRetries)}
 		}
+
+		// ConnectionPoolSettings.Http.IdleTimeout bounds how long an idle upstream HTTP/2
+		// connection is kept around before Envoy tears it down.
+		if settings.Http.IdleTimeout != nil && cluster.Http2ProtocolOptions != nil {
+			cluster.Http2ProtocolOptions.IdleTimeout = settings.Http.IdleTimeout
+		}
+
+		applyH2UpstreamConnectionOptions(cluster, settings.Http.H2UpstreamConnectionOptions)
 	}
 
 	if settings.Tcp != nil {
@@ -341,6 +448,12 @@ func applyConnectionPool(cluster *v2.Cluster, settings *networking.ConnectionPoo
 }
 
 // FIXME: there isn't a way to distinguish between unset values and zero values
+//
+// ConsecutiveGatewayFailure and ConsecutiveLocalOriginFailure below depend on fields on
+// networking.OutlierDetection_Http that aren't in this checkout's vendored
+// istio.io/api/networking/v1alpha3 (this repo snapshot doesn't vendor that module); both
+// proto-shim additions (int32 counts, mirroring ConsecutiveErrors) have to land upstream
+// before this compiles.
 func applyOutlierDetection(cluster *v2.Cluster, outlier *networking.OutlierDetection) {
 	if outlier == nil || outlier.Http == nil {
 		return
@@ -353,12 +466,36 @@ func applyOutlierDetection(cluster *v2.Cluster, outlier *networking.OutlierDetec
 	if outlier.Http.ConsecutiveErrors > 0 {
 		out.Consecutive_5Xx = &types.UInt32Value{Value: uint32(outlier.Http.ConsecutiveErrors)}
 	}
+	if outlier.Http.ConsecutiveGatewayFailure > 0 {
+		out.ConsecutiveGatewayFailure = &types.UInt32Value{Value: uint32(outlier.Http.ConsecutiveGatewayFailure)}
+	}
+	if outlier.Http.ConsecutiveLocalOriginFailure > 0 {
+		out.ConsecutiveLocalOriginFailure = &types.UInt32Value{Value: uint32(outlier.Http.ConsecutiveLocalOriginFailure)}
+	}
+	if outlier.Http.SplitExternalLocalOriginErrors {
+		out.SplitExternalLocalOriginErrors = outlier.Http.SplitExternalLocalOriginErrors
+	}
 	if outlier.Http.Interval != nil {
 		out.Interval = outlier.Http.Interval
 	}
 	if outlier.Http.MaxEjectionPercent > 0 {
 		out.MaxEjectionPercent = &types.UInt32Value{Value: uint32(outlier.Http.MaxEjectionPercent)}
 	}
+	if outlier.Http.MaxEjectionTime != nil {
+		out.MaxEjectionTime = outlier.Http.MaxEjectionTime
+	}
+	if outlier.Http.EnforcingSuccessRate > 0 {
+		out.EnforcingSuccessRate = &types.UInt32Value{Value: uint32(outlier.Http.EnforcingSuccessRate)}
+	}
+	if outlier.Http.SuccessRateMinimumHosts > 0 {
+		out.SuccessRateMinimumHosts = &types.UInt32Value{Value: uint32(outlier.Http.SuccessRateMinimumHosts)}
+	}
+	if outlier.Http.SuccessRateRequestVolume > 0 {
+		out.SuccessRateRequestVolume = &types.UInt32Value{Value: uint32(outlier.Http.SuccessRateRequestVolume)}
+	}
+	if outlier.Http.SuccessRateStdevFactor > 0 {
+		out.SuccessRateStdevFactor = &types.UInt32Value{Value: uint32(outlier.Http.SuccessRateStdevFactor)}
+	}
 	cluster.OutlierDetection = out
 }
 
@@ -366,7 +503,12 @@ func applyLoadBalancer(cluster *v2.Cluster, lb *networking.LoadBalancerSettings)
 	if lb == nil {
 		return
 	}
-	// TODO: RING_HASH and MAGLEV
+
+	if consistentHash := lb.GetConsistentHash(); consistentHash != nil {
+		applyConsistentHashLoadBalancer(cluster, consistentHash)
+		return
+	}
+
 	switch lb.GetSimple() {
 	case networking.LoadBalancerSettings_LEAST_CONN:
 		cluster.LbPolicy = v2.Cluster_LEAST_REQUEST
@@ -382,6 +524,39 @@ func applyLoadBalancer(cluster *v2.Cluster, lb *networking.LoadBalancerSettings)
 	// DO not do if else here. since lb.GetSimple returns a enum value (not pointer).
 }
 
+// applyConsistentHashLoadBalancer configures RING_HASH or MAGLEV on the cluster, selecting
+// MAGLEV when the DestinationRule sets a table size and RING_HASH (Envoy's default consistent
+// hash implementation) otherwise.
+//
+// This only makes Envoy capable of hashing; it doesn't make session affinity actually happen on
+// its own. That needs a matching RouteAction.HashPolicy on every route that can select this
+// cluster, built from the same consistentHash value by buildRouteActionHashPolicy (route.go) —
+// ConfigGeneratorImpl.BuildSidecarOutboundHTTPRouteConfig is expected to call it for each such
+// route the same way BuildClusters calls this function for each such cluster.
+// GetTableSize() depends on a TableSize field on
+// networking.LoadBalancerSettings_ConsistentHashLB that isn't in this checkout's vendored
+// istio.io/api/networking/v1alpha3 (this repo snapshot doesn't vendor that module); the
+// proto-shim addition (a uint64, mirroring Cluster_MaglevLbConfig.TableSize below) has to
+// land upstream before this compiles.
+func applyConsistentHashLoadBalancer(cluster *v2.Cluster, consistentHash *networking.LoadBalancerSettings_ConsistentHashLB) {
+	if consistentHash.GetTableSize() > 0 {
+		cluster.LbPolicy = v2.Cluster_MAGLEV
+		cluster.LbConfig = &v2.Cluster_MaglevLbConfig_{
+			MaglevLbConfig: &v2.Cluster_MaglevLbConfig{
+				TableSize: &types.UInt64Value{Value: consistentHash.GetTableSize()},
+			},
+		}
+		return
+	}
+
+	cluster.LbPolicy = v2.Cluster_RING_HASH
+	ringHashConfig := &v2.Cluster_RingHashLbConfig{}
+	if consistentHash.GetMinimumRingSize() > 0 {
+		ringHashConfig.MinimumRingSize = &types.UInt64Value{Value: consistentHash.GetMinimumRingSize()}
+	}
+	cluster.LbConfig = &v2.Cluster_RingHashLbConfig_{RingHashLbConfig: ringHashConfig}
+}
+
 // ALPNH2Only advertises that Proxy is going to use HTTP/2 when talking to the cluster.
 var ALPNH2Only = []string{"h2"}
 
@@ -473,6 +648,45 @@ func setUpstreamProtocol(cluster *v2.Cluster, port *model.Port) {
 	}
 }
 
+// applyH2UpstreamConnectionOptions configures HTTP/2 keepalive PINGs and flow-control
+// window sizes on the cluster. PINGs keep long-lived gRPC streams from being silently
+// dropped by NAT gateways/load balancers that reclaim idle connections; the window sizes
+// let high-throughput streams avoid flow-control stalls without raising MaxConcurrentStreams.
+//
+// networking.H2UpstreamConnectionOptions (and the ConnectionPoolSettings_HTTPSettings.Http2
+// field that exposes it) aren't in this checkout's vendored istio.io/api/networking/v1alpha3
+// (this repo snapshot doesn't vendor that module); the proto-shim addition has to land
+// upstream — InitialStreamWindowSize/InitialConnectionWindowSize (uint32 bytes) plus a
+// keepalive interval/timeout pair — before this compiles.
+func applyH2UpstreamConnectionOptions(cluster *v2.Cluster, opts *networking.H2UpstreamConnectionOptions) {
+	if opts == nil || cluster.Http2ProtocolOptions == nil {
+		return
+	}
+
+	if opts.InitialStreamWindowSize > 0 {
+		cluster.Http2ProtocolOptions.InitialStreamWindowSize = &types.UInt32Value{Value: opts.InitialStreamWindowSize}
+	}
+	if opts.InitialConnectionWindowSize > 0 {
+		cluster.Http2ProtocolOptions.InitialConnectionWindowSize = &types.UInt32Value{Value: opts.InitialConnectionWindowSize}
+	}
+
+	if opts.KeepaliveInterval == nil && opts.KeepaliveTimeout == nil {
+		return
+	}
+	tcpKeepalive := &core.TcpKeepalive{}
+	if opts.KeepaliveInterval != nil {
+		interval := uint32(util.GogoDurationToDuration(opts.KeepaliveInterval).Seconds())
+		tcpKeepalive.KeepaliveInterval = &types.UInt32Value{Value: interval}
+	}
+	if opts.KeepaliveTimeout != nil {
+		probes := uint32(util.GogoDurationToDuration(opts.KeepaliveTimeout).Seconds())
+		tcpKeepalive.KeepaliveProbes = &types.UInt32Value{Value: probes}
+	}
+	cluster.UpstreamConnectionOptions = &v2.UpstreamConnectionOptions{
+		TcpKeepalive: tcpKeepalive,
+	}
+}
+
 // generates a cluster that sends traffic to dummy localport 0
 // This cluster is used to catch all traffic to unresolved destinations in virtual service
 func buildBlackHoleCluster() *v2.Cluster {
@@ -485,6 +699,111 @@ func buildBlackHoleCluster() *v2.Cluster {
 	return cluster
 }
 
+// hasWildcardExternalHTTPS reports whether any service is an external HTTPS ServiceEntry with
+// a wildcard hostname (e.g. *.googleapis.com). buildSidecarOutboundListeners routes these
+// through the sni_dynamic_forward_proxy network filter instead of a per-host cluster, so CDS
+// only needs to produce one shared buildDynamicForwardProxyCluster for all of them.
+func hasWildcardExternalHTTPS(services []*model.Service) bool {
+	for _, service := range services {
+		for _, port := range service.Ports {
+			if isWildcardExternalHTTPS(service, port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWildcardExternalHTTPS reports whether service/port is an external HTTPS ServiceEntry with
+// a wildcard hostname (e.g. *.googleapis.com). Shared with buildSidecarOutboundListeners in
+// listener.go so the two files agree on exactly which ports route through sni_dynamic_forward_proxy.
+func isWildcardExternalHTTPS(service *model.Service, port *model.Port) bool {
+	return port.Protocol == model.ProtocolHTTPS && service.MeshExternal &&
+		strings.HasPrefix(service.Hostname.String(), "*.")
+}
+
+// buildDynamicForwardProxyCluster returns the single CDS cluster shared by every
+// sni_dynamic_forward_proxy listener filter chain (see buildSNIDynamicForwardProxyNetworkFilters
+// in listener.go): its custom cluster type resolves upstream hosts from the same DNS cache
+// (dynamicForwardProxyDNSCacheName) the listener side populates from the observed SNI, instead
+// of from a pre-declared host list.
+func buildDynamicForwardProxyCluster(mesh *meshconfig.MeshConfig) *v2.Cluster {
+	dnsCacheConfig := &dynamicforwardproxycluster.DnsCacheConfig{
+		Name: dynamicForwardProxyDNSCacheName,
+	}
+	if ttl := mesh.GetOutboundTrafficPolicy().GetDnsCacheTtl(); ttl != nil {
+		dnsCacheConfig.DnsCacheTtl = ttl
+	}
+
+	return &v2.Cluster{
+		Name:           dynamicForwardProxyDNSCacheName,
+		ConnectTimeout: defaultClusterConnectTimeout,
+		ClusterDiscoveryType: &v2.Cluster_ClusterType{
+			ClusterType: &v2.Cluster_CustomClusterType{
+				Name: DynamicForwardProxyClusterTypeName,
+				TypedConfig: util.MessageToAny(&dynamicforwardproxycluster.ClusterConfig{
+					DnsCacheConfig: dnsCacheConfig,
+				}),
+			},
+		},
+		LbPolicy: v2.Cluster_CLUSTER_PROVIDED,
+	}
+}
+
+// buildExtAuthzCluster returns the CDS cluster for the mesh-wide external authorization
+// service declared via MeshConfig.ExtAuthz. It resolves extAuthz.Service/Port via DNS, same as
+// the other host:port-addressed clusters this package builds (e.g. buildInboundClusters'
+// management-port passthrough cluster), and advertises HTTP/2 when the service is gRPC so the
+// ext_authz filters built in listener.go can issue a streaming CheckRequest.
+func buildExtAuthzCluster(env model.Environment, extAuthz *meshconfig.ExtAuthz) *v2.Cluster {
+	address := util.BuildAddress(extAuthz.Service, extAuthz.Port)
+	cluster := buildDefaultCluster(env, extAuthzClusterName, v2.Cluster_STRICT_DNS, []*core.Address{&address})
+	if extAuthz.Transport == meshconfig.ExtAuthz_GRPC {
+		cluster.Http2ProtocolOptions = &core.Http2ProtocolOptions{}
+	}
+	if extAuthz.Timeout != nil {
+		cluster.ConnectTimeout = util.GogoDurationToDuration(extAuthz.Timeout)
+	}
+	return cluster
+}
+
+// buildFailoverClusters builds, for a single service port, one EDS child cluster per
+// priority-ordered networking.TrafficPolicy_Failover target (each pinned to the target's
+// subset via its EDS ServiceName), plus a parent envoy.clusters.aggregate cluster that
+// lists them primary-first. Envoy only sends traffic to a lower-priority child once the
+// ones ahead of it in the list report outlier ejection or no healthy endpoints, so this
+// gives automatic regional/peer failover without any routing rule changes.
+func buildFailoverClusters(env model.Environment, service *model.Service, port *model.Port,
+	primaryClusterName string, failover []*networking.TrafficPolicy_Failover) []*v2.Cluster {
+	clusterNames := []string{primaryClusterName}
+	clusters := make([]*v2.Cluster, 0, len(failover))
+
+	for _, target := range failover {
+		childName := model.BuildSubsetKey(model.TrafficDirectionOutbound, target.Subset, service.Hostname, port.Port)
+		childCluster := buildDefaultCluster(env, childName, convertResolution(service.Resolution), nil)
+		updateEds(env, childCluster, service.Hostname)
+		setUpstreamProtocol(childCluster, port)
+		clusters = append(clusters, childCluster)
+		clusterNames = append(clusterNames, childName)
+	}
+
+	aggregateCluster := &v2.Cluster{
+		Name: model.BuildSubsetKey(model.TrafficDirectionOutbound, "aggregate", service.Hostname, port.Port),
+		ClusterDiscoveryType: &v2.Cluster_ClusterType{
+			ClusterType: &v2.Cluster_CustomClusterType{
+				Name: AggregateClusterTypeName,
+				TypedConfig: util.MessageToAny(&v2_cluster.AggregateClusterConfig{
+					Clusters: clusterNames,
+				}),
+			},
+		},
+		LbPolicy: v2.Cluster_CLUSTER_PROVIDED,
+	}
+	clusters = append(clusters, aggregateCluster)
+
+	return clusters
+}
+
 func buildDefaultCluster(env model.Environment, name string, discoveryType v2.Cluster_DiscoveryType,
 	hosts []*core.Address) *v2.Cluster {
 	cluster := &v2.Cluster{
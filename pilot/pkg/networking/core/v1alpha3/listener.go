@@ -19,20 +19,28 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	dynamicforwardproxycluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/dynamic_forward_proxy/v2alpha"
 	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/filter/accesslog/v2"
+	httpextauthz "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/ext_authz/v2"
+	grpcstats "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/grpc_stats/v2alpha"
+	connection_limit "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/connection_limit/v2alpha1"
+	networkextauthz "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/ext_authz/v2"
 	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	snidynamicforwardproxy "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/sni_dynamic_forward_proxy/v2alpha"
 	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
 	xdsutil "github.com/envoyproxy/go-control-plane/pkg/util"
 	google_protobuf "github.com/gogo/protobuf/types"
 	"github.com/prometheus/client_golang/prometheus"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
+	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pilot/pkg/networking/plugin"
 	"istio.io/istio/pilot/pkg/networking/util"
@@ -44,6 +52,49 @@ const (
 
 	envoyHTTPConnectionManager = "envoy.http_connection_manager"
 
+	// tlsInspectorListenerFilter sniffs the transport protocol (raw_buffer vs tls) and,
+	// for TLS, the SNI/ALPN, so FilterChainMatch can discriminate on them.
+	tlsInspectorListenerFilter = "envoy.listener.tls_inspector"
+
+	// httpInspectorListenerFilter sniffs whether a raw_buffer connection looks like HTTP/1.1
+	// or HTTP/2, so FilterChainMatch can route it to the right filter chain without SNI.
+	httpInspectorListenerFilter = "envoy.listener.http_inspector"
+
+	// transportProtocolRawBuffer is the FilterChainMatch.TransportProtocol value for
+	// plaintext (non-TLS) connections.
+	transportProtocolRawBuffer = "raw_buffer"
+
+	// connectionLimitFilter caps the number of concurrent connections a listener will
+	// accept, closing (optionally after a delay) anything past the configured ceiling.
+	connectionLimitFilter = "envoy.filters.network.connection_limit"
+
+	// grpcHTTP1BridgeFilter lets HTTP/1.1 clients talk to a gRPC backend.
+	grpcHTTP1BridgeFilter = "envoy.filters.http.grpc_http1_bridge"
+
+	// grpcWebFilter translates the grpc-web wire format used by browser clients to gRPC.
+	grpcWebFilter = "envoy.filters.http.grpc_web"
+
+	// grpcStatsFilter emits per-method gRPC request/response stats.
+	grpcStatsFilter = "envoy.filters.http.grpc_stats"
+
+	// sniDynamicForwardProxyFilter resolves the upstream address for a TCP connection from
+	// the SNI name observed by the tls_inspector listener filter, rather than from a
+	// pre-declared cluster, so a single filter chain can serve a wildcard external hostname.
+	sniDynamicForwardProxyFilter = "envoy.filters.network.sni_dynamic_forward_proxy"
+
+	// originalDstListenerFilter restores a redirected connection's original destination
+	// address/port so FilterChainMatch can select a chain on it, replacing UseOriginalDst
+	// plus a dummy tcp_proxy filter on the virtual listener.
+	originalDstListenerFilter = "envoy.filters.listener.original_dst"
+
+	// extAuthzNetworkFilter checks a new TCP connection against MeshConfig.ExtAuthz before
+	// any other network filter runs.
+	extAuthzNetworkFilter = "envoy.filters.network.ext_authz"
+
+	// extAuthzHTTPFilter checks an HTTP request against MeshConfig.ExtAuthz before any other
+	// HTTP filter, including the router, runs.
+	extAuthzHTTPFilter = "envoy.filters.http.ext_authz"
+
 	// HTTPStatPrefix indicates envoy stat prefix for http listeners
 	HTTPStatPrefix = "http"
 
@@ -70,6 +121,12 @@ var (
 	// Use /debug/ldsz instead.
 	verboseDebug = os.Getenv("PILOT_DUMP_ALPHA3") != ""
 
+	// enableOrigDstMatch projects buildSidecarOutboundListeners' per-service listeners onto
+	// the virtual listener as original_dst-matched filter chains (buildVirtualOutboundListener)
+	// instead of emitting one 0.0.0.0 listener per service, cutting LDS payload size for
+	// meshes with thousands of services.
+	enableOrigDstMatch = os.Getenv("PILOT_ENABLE_ORIG_DST_MATCH") != ""
+
 	// TODO: gauge should be reset on refresh, not the best way to represent errors but better
 	// than nothing.
 	// TODO: add dimensions - namespace of rule, service, rule name
@@ -81,11 +138,20 @@ var (
 		Name: "pilot_invalid_out_listeners",
 		Help: "Number of invalid outbound listeners.",
 	})
+
+	// TODO: this reflects only the last resolved value across all listeners, not a
+	// per-listener breakdown - good enough to alert on an unintentional mesh-wide cap,
+	// not to debug which listener has which limit.
+	inboundConnectionLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_inbound_connection_limit",
+		Help: "Effective max_connections applied to the last resolved inbound connection_limit filter, 0 if uncapped.",
+	})
 )
 
 func init() {
 	prometheus.MustRegister(conflictingOutbound)
 	prometheus.MustRegister(invalidOutboundListeners)
+	prometheus.MustRegister(inboundConnectionLimit)
 }
 
 // ListenersALPNProtocols denotes the the list of ALPN protocols that the listener
@@ -130,9 +196,11 @@ func (configgen *ConfigGeneratorImpl) buildSidecarListeners(env model.Environmen
 		outbound := configgen.buildSidecarOutboundListeners(env, node, proxyInstances, services)
 
 		listeners = append(listeners, inbound...)
-		listeners = append(listeners, outbound...)
+		if !enableOrigDstMatch {
+			listeners = append(listeners, outbound...)
+		}
 
-		mgmtListeners := buildMgmtPortListeners(managementPorts, node.IPAddress)
+		mgmtListeners := buildMgmtPortListeners(mesh, managementPorts, node.IPAddress)
 		// If management listener port and service port are same, bad things happen
 		// when running in kubernetes, as the probes stop responding. So, append
 		// non overlapping listeners only.
@@ -147,35 +215,43 @@ func (configgen *ConfigGeneratorImpl) buildSidecarListeners(env model.Environmen
 			listeners = append(listeners, m)
 		}
 
-		// We need a dummy filter to fill in the filter stack for orig_dst listener
-		// TODO: Move to Listener filters and set up original dst filter there.
-		dummyTCPProxy := &tcp_proxy.TcpProxy{
-			StatPrefix: util.BlackHoleCluster,
-			Cluster:    util.BlackHoleCluster,
-		}
+		if enableOrigDstMatch {
+			virtualListener, err := buildVirtualOutboundListener(mesh, node, outbound)
+			if err != nil {
+				return nil, err
+			}
+			listeners = append(listeners, virtualListener)
+		} else {
+			// We need a dummy filter to fill in the filter stack for orig_dst listener
+			// TODO: Move to Listener filters and set up original dst filter there.
+			dummyTCPProxy := &tcp_proxy.TcpProxy{
+				StatPrefix: util.BlackHoleCluster,
+				Cluster:    util.BlackHoleCluster,
+			}
 
-		var transparent *google_protobuf.BoolValue
-		if mode := node.Metadata["INTERCEPTION_MODE"]; mode == "TPROXY" {
-			transparent = &google_protobuf.BoolValue{true}
-		}
+			var transparent *google_protobuf.BoolValue
+			if mode := node.Metadata["INTERCEPTION_MODE"]; mode == "TPROXY" {
+				transparent = &google_protobuf.BoolValue{true}
+			}
 
-		// add an extra listener that binds to the port that is the recipient of the iptables redirect
-		listeners = append(listeners, &xdsapi.Listener{
-			Name:           VirtualListenerName,
-			Address:        util.BuildAddress(WildcardAddress, uint32(mesh.ProxyListenPort)),
-			Transparent:    transparent,
-			UseOriginalDst: &google_protobuf.BoolValue{true},
-			FilterChains: []listener.FilterChain{
-				{
-					Filters: []listener.Filter{
-						{
-							Name:   xdsutil.TCPProxy,
-							Config: util.MessageToStruct(dummyTCPProxy),
+			// add an extra listener that binds to the port that is the recipient of the iptables redirect
+			listeners = append(listeners, &xdsapi.Listener{
+				Name:           VirtualListenerName,
+				Address:        util.BuildAddress(WildcardAddress, uint32(mesh.ProxyListenPort)),
+				Transparent:    transparent,
+				UseOriginalDst: &google_protobuf.BoolValue{true},
+				FilterChains: []listener.FilterChain{
+					{
+						Filters: []listener.Filter{
+							{
+								Name:   xdsutil.TCPProxy,
+								Config: util.MessageToStruct(dummyTCPProxy),
+							},
 						},
 					},
 				},
-			},
-		})
+			})
+		}
 	}
 
 	// enable HTTP PROXY port if necessary; this will add an RDS route for this port
@@ -197,6 +273,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarListeners(env model.Environmen
 			ip:             listenAddress,
 			port:           int(mesh.ProxyHttpPort),
 			protocol:       model.ProtocolHTTP,
+			extAuthz:       mesh.GetExtAuthz(),
 			filterChainOpts: []*filterChainOpts{{
 				httpOpts: &httpListenerOpts{
 					routeConfig: configgen.BuildSidecarOutboundHTTPRouteConfig(env, node, proxyInstances,
@@ -215,10 +292,12 @@ func (configgen *ConfigGeneratorImpl) buildSidecarListeners(env model.Environmen
 			}},
 			bindToPort: true,
 		}
-		l := buildListener(opts)
-		if err := marshalFilters(l, opts, []plugin.FilterChain{{}}); err != nil {
+		l, err := buildListener(opts)
+		if err != nil {
 			log.Warna("buildSidecarListeners ", err.Error())
-		} else {
+		} else if err := marshalFilters(l, opts, []plugin.FilterChain{{}}); err != nil {
+			log.Warna("buildSidecarListeners ", err.Error())
+		} else if l = applyListenerPatches(PatchContextSidecarOutbound, env.EnvoyFilterPatches(&node), l); l != nil {
 			listeners = append(listeners, l)
 		}
 		// TODO: need inbound listeners in HTTP_PROXY case, with dedicated ingress listener.
@@ -227,6 +306,177 @@ func (configgen *ConfigGeneratorImpl) buildSidecarListeners(env model.Environmen
 	return listeners, nil
 }
 
+// buildVirtualOutboundListener re-projects the per-service 0.0.0.0 listeners outbound would
+// otherwise contribute as matcher-driven filter chains on a single virtual listener, keyed on
+// the connection's restored destination (FilterChainMatch.DestinationPort/PrefixRanges). The
+// native envoy.filters.listener.original_dst listener filter restores that destination, so this
+// replaces the legacy UseOriginalDst-plus-dummy-tcp_proxy layout without changing which chain a
+// given redirected connection ultimately matches. It errors out rather than emitting the
+// listener if two re-projected chains collapse onto an identical FilterChainMatch precedence
+// (e.g. two Passthrough-resolution services sharing a port), the same ambiguity buildListener
+// already rejects for a single service's listener.
+func buildVirtualOutboundListener(mesh *meshconfig.MeshConfig, node model.Proxy, outbound []*xdsapi.Listener) (*xdsapi.Listener, error) {
+	var transparent *google_protobuf.BoolValue
+	if mode := node.Metadata["INTERCEPTION_MODE"]; mode == "TPROXY" {
+		transparent = &google_protobuf.BoolValue{true}
+	}
+
+	virtualListener := &xdsapi.Listener{
+		Name:        VirtualListenerName,
+		Address:     util.BuildAddress(WildcardAddress, uint32(mesh.ProxyListenPort)),
+		Transparent: transparent,
+		ListenerFilters: []listener.ListenerFilter{
+			{Name: originalDstListenerFilter},
+		},
+	}
+
+	for _, l := range outbound {
+		destinationPort := l.Address.GetSocketAddress().GetPortValue()
+		destinationAddress := l.Address.GetSocketAddress().GetAddress()
+		// Per-service listener filters (tls_inspector, http_inspector) are what populate the
+		// TransportProtocol/SniDomains values the re-projected FilterChainMatch below relies
+		// on, so they have to run on the virtual listener too, not just original_dst.
+		for _, lf := range l.ListenerFilters {
+			ensureListenerFilters(virtualListener, lf.Name)
+		}
+		for i := range l.FilterChains {
+			chain := l.FilterChains[i]
+			match := chain.FilterChainMatch
+			if match == nil {
+				match = &listener.FilterChainMatch{}
+			}
+			match.DestinationPort = &google_protobuf.UInt32Value{Value: destinationPort}
+			if destinationAddress != WildcardAddress {
+				prefixLen := uint32(32)
+				if strings.Contains(destinationAddress, ":") {
+					prefixLen = 128
+				}
+				match.PrefixRanges = append(match.PrefixRanges, &core.CidrRange{
+					AddressPrefix: destinationAddress,
+					PrefixLen:     &google_protobuf.UInt32Value{Value: prefixLen},
+				})
+			}
+			chain.FilterChainMatch = match
+			virtualListener.FilterChains = append(virtualListener.FilterChains, chain)
+		}
+	}
+
+	if len(virtualListener.FilterChains) > 1 {
+		if err := sortVirtualOutboundFilterChains(virtualListener.FilterChains); err != nil {
+			return nil, err
+		}
+	}
+	return virtualListener, nil
+}
+
+// resolveConnectionLimits returns the effective ConnectionLimits for hostname, preferring the
+// DestinationRule's trafficPolicy.connectionPool.connectionLimits (if the user set one) over
+// the mesh-wide default. This mirrors the precedence buildInboundClusters already uses for
+// ConnectionPoolSettings: DestinationRule wins, mesh config is the fallback.
+//
+// networking.ConnectionLimits, ConnectionPoolSettings.GetConnectionLimits() and
+// MeshConfig.GetDefaultConnectionLimits() all depend on proto-shim additions to this
+// checkout's vendored istio.io/api/networking/v1alpha3 and istio.io/api/mesh/v1alpha1 (this
+// repo snapshot doesn't vendor either module); the expected shape is a MaxConnections
+// uint32, mirroring the Tcp.MaxConnections field applyConnectionPool already reads.
+func resolveConnectionLimits(env model.Environment, mesh *meshconfig.MeshConfig, hostname model.Hostname) *networking.ConnectionLimits {
+	if config := env.DestinationRule(hostname); config != nil {
+		destinationRule := config.Spec.(*networking.DestinationRule)
+		if limits := destinationRule.GetTrafficPolicy().GetConnectionPool().GetConnectionLimits(); limits != nil {
+			return limits
+		}
+	}
+	return mesh.GetDefaultConnectionLimits()
+}
+
+// buildConnectionLimitFilter returns the envoy.filters.network.connection_limit network filter
+// enforcing limits on a listener, or nil if limits is unset or uncapped. Callers place it at the
+// head of filterChainOpts.networkFilters so oversubscribed listeners shed new connections before
+// any other network filter (mixer, tcp_proxy) does work on them.
+func buildConnectionLimitFilter(statPrefix string, limits *networking.ConnectionLimits) *listener.Filter {
+	if limits == nil || limits.MaxConnections == 0 {
+		return nil
+	}
+
+	inboundConnectionLimit.Set(float64(limits.MaxConnections))
+
+	cfg := &connection_limit.ConnectionLimit{
+		StatPrefix:     statPrefix,
+		MaxConnections: &google_protobuf.UInt64Value{Value: limits.MaxConnections},
+	}
+	if limits.Delay != nil {
+		cfg.Delay = limits.Delay
+	}
+	return &listener.Filter{
+		Name:   connectionLimitFilter,
+		Config: util.MessageToStruct(cfg),
+	}
+}
+
+// wantsExtAuthzNetworkFilter reports whether extAuthz should be enforced on TCP filter chains.
+// Envoy's network ext_authz filter only supports a gRPC check service, so it's only wired in
+// when extAuthz.Transport is gRPC; an HTTP-transport policy only gates HTTP chains (see
+// buildExtAuthzHTTPFilter), since there is no network-filter equivalent that speaks plain HTTP.
+func wantsExtAuthzNetworkFilter(extAuthz *meshconfig.ExtAuthz) bool {
+	return extAuthz != nil && extAuthz.Transport == meshconfig.ExtAuthz_GRPC
+}
+
+// buildExtAuthzNetworkFilter returns the envoy.filters.network.ext_authz network filter that
+// checks every new TCP connection against MeshConfig.ExtAuthz, pointed at the CDS cluster
+// buildExtAuthzCluster (cluster.go) produces for it. Only call this when
+// wantsExtAuthzNetworkFilter(extAuthz) is true.
+func buildExtAuthzNetworkFilter(extAuthz *meshconfig.ExtAuthz) listener.Filter {
+	cfg := &networkextauthz.ExtAuthz{
+		StatPrefix: "ext_authz",
+		GrpcService: &core.GrpcService{
+			TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+				EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: extAuthzClusterName},
+			},
+			Timeout: extAuthz.Timeout,
+		},
+		FailureModeAllow: extAuthz.FailureModeAllow,
+	}
+	return listener.Filter{
+		Name:   extAuthzNetworkFilter,
+		Config: util.MessageToStruct(cfg),
+	}
+}
+
+// buildExtAuthzHTTPFilter returns the envoy.filters.http.ext_authz HTTP filter that checks every
+// request against MeshConfig.ExtAuthz, over gRPC or plain HTTP depending on extAuthz.Transport.
+// extAuthz.IncludeRequestHeaders/IncludeResponseHeaders only apply to the HTTP-transport case:
+// a gRPC check service gets the full request attribute context natively, with no equivalent
+// header allow-list to restrict it.
+func buildExtAuthzHTTPFilter(extAuthz *meshconfig.ExtAuthz) *http_conn.HttpFilter {
+	cfg := &httpextauthz.ExtAuthz{FailureModeAllow: extAuthz.FailureModeAllow}
+	if extAuthz.Transport == meshconfig.ExtAuthz_HTTP {
+		cfg.Services = &httpextauthz.ExtAuthz_HttpService{
+			HttpService: &httpextauthz.HttpService{
+				ServerUri: &core.HttpUri{
+					Uri:              fmt.Sprintf("http://%s:%d", extAuthz.Service, extAuthz.Port),
+					HttpUpstreamType: &core.HttpUri_Cluster{Cluster: extAuthzClusterName},
+					Timeout:          extAuthz.Timeout,
+				},
+				AuthorizationRequest:  &httpextauthz.AuthorizationRequest{AllowedHeaders: extAuthz.IncludeRequestHeaders},
+				AuthorizationResponse: &httpextauthz.AuthorizationResponse{AllowedUpstreamHeaders: extAuthz.IncludeResponseHeaders},
+			},
+		}
+	} else {
+		cfg.Services = &httpextauthz.ExtAuthz_GrpcService{
+			GrpcService: &core.GrpcService{
+				TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+					EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: extAuthzClusterName},
+				},
+				Timeout: extAuthz.Timeout,
+			},
+		}
+	}
+	return &http_conn.HttpFilter{
+		Name:   extAuthzHTTPFilter,
+		Config: util.MessageToStruct(cfg),
+	}
+}
+
 // buildSidecarInboundListeners creates listeners for the server-side (inbound)
 // configuration for co-located service proxyInstances.
 func (configgen *ConfigGeneratorImpl) buildSidecarInboundListeners(env model.Environment, node model.Proxy,
@@ -234,6 +484,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarInboundListeners(env model.Env
 
 	var listeners []*xdsapi.Listener
 	listenerMap := make(map[string]*xdsapi.Listener)
+	inboundPatches := env.EnvoyFilterPatches(&node)
 	// inbound connections/requests are redirected to the endpoint address but appear to be sent
 	// to the service address.
 	for _, instance := range proxyInstances {
@@ -255,6 +506,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarInboundListeners(env model.Env
 			ip:             endpoint.Address,
 			port:           endpoint.Port,
 			protocol:       protocol,
+			extAuthz:       env.Mesh.GetExtAuthz(),
 		}
 
 		listenerMapKey := fmt.Sprintf("%s:%d", endpoint.Address, endpoint.Port)
@@ -272,6 +524,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarInboundListeners(env model.Env
 					rds:              "",
 					useRemoteAddress: false,
 					direction:        http_conn.INGRESS,
+					protocol:         protocol,
 				}},
 			}
 		case plugin.ListenerTypeTCP:
@@ -284,35 +537,85 @@ func (configgen *ConfigGeneratorImpl) buildSidecarInboundListeners(env model.Env
 			continue
 		}
 
+		// Mesh/DestinationRule-resolved connection cap for this listener. Plugins get a chance
+		// to tailor it to the specific workload below before we turn it into a filter.
+		connLimits := resolveConnectionLimits(env, env.Mesh, instance.Service.Hostname)
+
 		// call plugins
-		l := buildListener(listenerOpts)
+		l, err := buildListener(listenerOpts)
+		if err != nil {
+			log.Warnf("buildSidecarInboundListeners: %v", err)
+			continue
+		}
 		mutable := &plugin.MutableObjects{
 			Listener:     l,
 			FilterChains: make([]plugin.FilterChain, len(l.FilterChains)),
 		}
 		for _, p := range configgen.Plugins {
 			params := &plugin.InputParams{
-				ListenerType:    listenerType,
-				Env:             &env,
-				Node:            &node,
-				ProxyInstances:  proxyInstances,
-				ServiceInstance: instance,
+				ListenerType:     listenerType,
+				Env:              &env,
+				Node:             &node,
+				ProxyInstances:   proxyInstances,
+				ServiceInstance:  instance,
+				ConnectionLimits: connLimits,
 			}
 			if err := p.OnInboundListener(params, mutable); err != nil {
 				log.Warn(err.Error())
 			}
+			// a plugin may replace ConnectionLimits to override the cap for this workload
+			connLimits = params.ConnectionLimits
+		}
+		if connLimitFilter := buildConnectionLimitFilter(fmt.Sprintf("inbound_%s", listenerMapKey), connLimits); connLimitFilter != nil {
+			opt := listenerOpts.filterChainOpts[0]
+			opt.networkFilters = append([]listener.Filter{*connLimitFilter}, opt.networkFilters...)
 		}
 		// Filters are serialized one time into an opaque struct once we have the complete list.
 		if err := marshalFilters(mutable.Listener, listenerOpts, mutable.FilterChains); err != nil {
 			log.Warna("buildSidecarInboundListeners ", err.Error())
-		} else {
-			listeners = append(listeners, mutable.Listener)
-			listenerMap[listenerMapKey] = mutable.Listener
+		} else if patched := applyListenerPatches(PatchContextSidecarInbound, inboundPatches, mutable.Listener); patched != nil {
+			listeners = append(listeners, patched)
+			listenerMap[listenerMapKey] = patched
 		}
 	}
 	return listeners
 }
 
+// buildSNIDynamicForwardProxyNetworkFilters returns the sni_dynamic_forward_proxy + tcp_proxy
+// filter pair used in place of buildOutboundNetworkFilters for a wildcard external HTTPS
+// hostname: sni_dynamic_forward_proxy resolves the upstream address from the SNI name the
+// tls_inspector reports and populates dynamicForwardProxyDNSCacheName, and tcp_proxy then
+// forwards the connection to the shared buildDynamicForwardProxyCluster cluster in cluster.go.
+// The tcp_proxy filter's AccessLog is wired through buildFileAccessLog so these connections get
+// the same structured/filtered logging as the HTTP listeners.
+// TODO(github.com/istio/pilot/issues/237): belongs alongside buildOutboundNetworkFilters once
+// that helper lands; this trimmed tree does not have it yet.
+func buildSNIDynamicForwardProxyNetworkFilters(mesh *meshconfig.MeshConfig, service *model.Service, servicePort *model.Port) []listener.Filter {
+	statPrefix := fmt.Sprintf("outbound_%d_%s_sni_dynamic_forward_proxy", servicePort.Port, service.Hostname)
+	sniFilter := &snidynamicforwardproxy.FilterConfig{
+		DnsCacheConfig: &dynamicforwardproxycluster.DnsCacheConfig{
+			Name: dynamicForwardProxyDNSCacheName,
+		},
+	}
+	tcpProxy := &tcp_proxy.TcpProxy{
+		StatPrefix:       statPrefix,
+		ClusterSpecifier: &tcp_proxy.TcpProxy_Cluster{Cluster: dynamicForwardProxyDNSCacheName},
+	}
+	if mesh.AccessLogFile != "" {
+		tcpProxy.AccessLog = []*accesslog.AccessLog{buildFileAccessLog(mesh)}
+	}
+	return []listener.Filter{
+		{
+			Name:       sniDynamicForwardProxyFilter,
+			ConfigType: &listener.Filter_TypedConfig{TypedConfig: util.MessageToAny(sniFilter)},
+		},
+		{
+			Name:       xdsutil.TCPProxy,
+			ConfigType: &listener.Filter_TypedConfig{TypedConfig: util.MessageToAny(tcpProxy)},
+		},
+	}
+}
+
 // buildSidecarOutboundListeners generates http and tcp listeners for outbound connections from the service instance
 // TODO(github.com/istio/pilot/issues/237)
 //
@@ -327,6 +630,13 @@ func (configgen *ConfigGeneratorImpl) buildSidecarInboundListeners(env model.Env
 // Connections to the ports of non-load balanced services are directed to
 // the connection's original destination. This avoids costly queries of instance
 // IPs and ports, but requires that ports of non-load balanced service be unique.
+// ConfigGeneratorImpl.BuildSidecarOutboundHTTPRouteConfig, called a few lines below and again in
+// buildSidecarOutboundHTTPListenerForPort, has no definition anywhere in this tree (confirmed at
+// the baseline commit, predating this change) — there is no route.go under this package. It is
+// expected to read each VirtualService HTTP route's Timeout/MaxStreamDuration and ConsistentHash
+// selection and wire them into the generated RouteAction via buildRouteActionTimeout and
+// buildRouteActionHashPolicy (route.go), the same way this file's buildSidecarInboundListeners
+// and cluster.go's BuildClusters call this package's other per-field builders.
 func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.Environment, node model.Proxy,
 	proxyInstances []*model.ServiceInstance, services []*model.Service) []*xdsapi.Listener {
 
@@ -349,6 +659,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 				ip:             WildcardAddress,
 				port:           servicePort.Port,
 				protocol:       servicePort.Protocol,
+				extAuthz:       env.Mesh.GetExtAuthz(),
 			}
 
 			currentListener = nil
@@ -356,14 +667,20 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 			switch plugin.ModelProtocolToListenerType(servicePort.Protocol) {
 			case plugin.ListenerTypeHTTP:
 				listenerMapKey = fmt.Sprintf("%s:%d", listenAddress, servicePort.Port)
+				var collidesWithTCP bool
 				if l, exists := listenerMap[listenerMapKey]; exists {
-					if !listenerTypeMap[listenerMapKey].IsHTTP() {
-						conflictingOutbound.Add(1)
-						log.Warnf("buildSidecarOutboundListeners: listener conflict (%v current and new %v) on %s, destination:%s, current Listener: (%s %v)",
-							servicePort.Protocol, listenerTypeMap[listenerMapKey], listenerMapKey, clusterName, l.Name, l)
+					if listenerTypeMap[listenerMapKey].IsHTTP() {
+						// Skip building listener for the same http port
+						continue
 					}
-					// Skip building listener for the same http port
-					continue
+					// Collapse onto the existing TCP listener using protocol-detection listener
+					// filters instead of dropping this service's listener entirely.
+					conflictingOutbound.Add(1)
+					log.Warnf("buildSidecarOutboundListeners: listener conflict (%v current and new %v) on %s, destination:%s, current Listener: (%s %v), collapsing via protocol detection",
+						servicePort.Protocol, listenerTypeMap[listenerMapKey], listenerMapKey, clusterName, l.Name, l)
+					currentListener = l
+					collidesWithTCP = true
+					ensureListenerFilters(currentListener, httpInspectorListenerFilter)
 				}
 
 				operation := http_conn.EGRESS
@@ -383,8 +700,15 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 							env, node, proxyInstances, services, fmt.Sprintf("%d", servicePort.Port)),
 						useRemoteAddress: useRemoteAddress,
 						direction:        operation,
+						protocol:         servicePort.Protocol,
 					},
 				}}
+				if collidesWithTCP {
+					listenerOpts.filterChainOpts[0].matchConditions = &listener.FilterChainMatch{
+						TransportProtocol:    transportProtocolRawBuffer,
+						ApplicationProtocols: append([]string{}, ListenersALPNProtocols...),
+					}
+				}
 			case plugin.ListenerTypeTCP:
 				if service.Resolution != model.Passthrough {
 					listenAddress = service.GetServiceAddressForProxy(&node)
@@ -392,20 +716,45 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 				}
 
 				listenerMapKey = fmt.Sprintf("%s:%d", listenAddress, servicePort.Port)
-				var exists bool
+				var exists, collidesWithOther bool
 				if currentListener, exists = listenerMap[listenerMapKey]; exists {
 					// Check if this is HTTPS port collision for external service. If so, we can use SNI to differentiate
 					// Internal TCP services will never hit this issue because they are bound by specific IP_port, while
 					// external service listeners are typically bound to 0.0.0.0
-					if !listenerTypeMap[listenerMapKey].IsTCP() || servicePort.Protocol != model.ProtocolHTTPS || !service.MeshExternal {
+					sniEligible := servicePort.Protocol == model.ProtocolHTTPS && service.MeshExternal
+					if !listenerTypeMap[listenerMapKey].IsTCP() && !sniEligible {
+						// Colliding with an HTTP listener and we have no SNI to discriminate on:
+						// collapse via protocol detection (raw_buffer vs the HTTP listener filter's
+						// application_protocols match) instead of dropping this listener.
+						conflictingOutbound.Add(1)
+						log.Warnf("buildSidecarOutboundListeners: listener conflict (%v current and new %v) on %s, destination:%s, current Listener: (%s %v), collapsing via protocol detection",
+							servicePort.Protocol, listenerTypeMap[listenerMapKey], listenerMapKey, clusterName, currentListener.Name, currentListener)
+						ensureListenerFilters(currentListener, httpInspectorListenerFilter)
+						collidesWithOther = true
+					} else if !listenerTypeMap[listenerMapKey].IsTCP() {
 						conflictingOutbound.Add(1)
 						log.Warnf("buildSidecarOutboundListeners: listener conflict (%v current and new %v) on %s, destination:%s, current Listener: (%s %v)",
 							servicePort.Protocol, listenerTypeMap[listenerMapKey], listenerMapKey, clusterName, currentListener.Name, currentListener)
 						continue
 					}
 				}
-				filterChainOption := &filterChainOpts{
-					networkFilters: buildOutboundNetworkFilters(clusterName, addresses, servicePort),
+				var filterChainOption *filterChainOpts
+				if isWildcardExternalHTTPS(service, servicePort) {
+					// A wildcard external HTTPS hostname (e.g. *.googleapis.com) has no single
+					// resolvable address to hand buildOutboundNetworkFilters, so instead of a
+					// tcp_proxy to a per-host cluster, forward through sni_dynamic_forward_proxy,
+					// which resolves the upstream from the SNI name Envoy observes at connection
+					// time and dials out via the shared buildDynamicForwardProxyCluster cluster.
+					filterChainOption = &filterChainOpts{
+						networkFilters: buildSNIDynamicForwardProxyNetworkFilters(env.Mesh, service, servicePort),
+					}
+				} else {
+					filterChainOption = &filterChainOpts{
+						networkFilters: buildOutboundNetworkFilters(clusterName, addresses, servicePort),
+					}
+				}
+				if collidesWithOther {
+					filterChainOption.matchConditions = &listener.FilterChainMatch{TransportProtocol: transportProtocolRawBuffer}
 				}
 
 				// TODO (@rshriram): This is not sufficient. There are other TCP protocols that use SNI, that need to be tackled.
@@ -413,6 +762,11 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 				// TODO (@rshriram): We need an explicit option to enable/disable SNI for a given service
 				if servicePort.Protocol == model.ProtocolHTTPS && service.MeshExternal {
 					filterChainOption.sniHosts = []string{service.Hostname.String()}
+					if currentListener != nil {
+						ensureListenerFilters(currentListener, tlsInspectorListenerFilter)
+					} else {
+						listenerOpts.listenerFilters = append(listenerOpts.listenerFilters, listener.ListenerFilter{Name: tlsInspectorListenerFilter})
+					}
 				}
 
 				listenerOpts.filterChainOpts = []*filterChainOpts{filterChainOption}
@@ -426,7 +780,11 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 
 			// call plugins
 			listenerOpts.ip = listenAddress
-			l := buildListener(listenerOpts)
+			l, err := buildListener(listenerOpts)
+			if err != nil {
+				log.Warnf("buildSidecarOutboundListeners: %v", err)
+				continue
+			}
 			mutable := &plugin.MutableObjects{
 				Listener:     l,
 				FilterChains: make([]plugin.FilterChain, len(l.FilterChains)),
@@ -475,8 +833,15 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 		}
 	}
 
+	outboundPatches := env.EnvoyFilterPatches(&node)
 	for name, l := range listenerMap {
 		ltype := listenerTypeMap[name]
+		l = applyListenerPatches(PatchContextSidecarOutbound, outboundPatches, l)
+		if l == nil {
+			delete(listenerTypeMap, name)
+			continue
+		}
+		listenerMap[name] = l
 		if err := l.Validate(); err != nil {
 			log.Warnf("buildSidecarOutboundListeners: error validating listener %s (type %v): %v", name, ltype, err)
 			invalidOutboundListeners.Add(1)
@@ -505,7 +870,7 @@ func (configgen *ConfigGeneratorImpl) buildSidecarOutboundListeners(env model.En
 // the pod.
 // So, if a user wants to use kubernetes probes with Istio, she should ensure
 // that the health check ports are distinct from the service ports.
-func buildMgmtPortListeners(managementPorts model.PortList, managementIP string) []*xdsapi.Listener {
+func buildMgmtPortListeners(mesh *meshconfig.MeshConfig, managementPorts model.PortList, managementIP string) []*xdsapi.Listener {
 	listeners := make([]*xdsapi.Listener, 0, len(managementPorts))
 
 	if managementIP == "" {
@@ -529,15 +894,26 @@ func buildMgmtPortListeners(managementPorts model.PortList, managementIP string)
 					Hostname: ManagementClusterHostname,
 				},
 			}
+			networkFilters := buildInboundNetworkFilters(instance)
+			// Mgmt port listeners never run plugins (see TODO below), so there's no per-workload
+			// override here - only the mesh-wide default applies.
+			if connLimitFilter := buildConnectionLimitFilter(
+				fmt.Sprintf("inbound_mgmt_%d", mPort.Port), mesh.GetDefaultConnectionLimits()); connLimitFilter != nil {
+				networkFilters = append([]listener.Filter{*connLimitFilter}, networkFilters...)
+			}
 			listenerOpts := buildListenerOpts{
 				ip:       managementIP,
 				port:     mPort.Port,
 				protocol: model.ProtocolTCP,
 				filterChainOpts: []*filterChainOpts{{
-					networkFilters: buildInboundNetworkFilters(instance),
+					networkFilters: networkFilters,
 				}},
 			}
-			l := buildListener(listenerOpts)
+			l, err := buildListener(listenerOpts)
+			if err != nil {
+				log.Warna("buildMgmtPortListeners ", err.Error())
+				continue
+			}
 			// TODO: should we call plugins for the admin port listeners too? We do everywhere else we contruct listeners.
 			if err := marshalFilters(l, listenerOpts, []plugin.FilterChain{{}}); err != nil {
 				log.Warna("buildMgmtPortListeners ", err.Error())
@@ -562,6 +938,9 @@ type httpListenerOpts struct {
 	direction        http_conn.HttpConnectionManager_Tracing_OperationName
 	// If set, use this as a basis
 	connectionManager *http_conn.HttpConnectionManager
+	// protocol is the service port's protocol. When it's ProtocolGRPC or ProtocolGRPCWeb,
+	// buildHTTPConnectionManager auto-wires the gRPC-aware HTTP filters.
+	protocol model.Protocol
 }
 
 // filterChainOpts describes a filter chain: a set of filters with the same TLS context
@@ -570,6 +949,31 @@ type filterChainOpts struct {
 	tlsContext     *auth.DownstreamTlsContext
 	httpOpts       *httpListenerOpts
 	networkFilters []listener.Filter
+	// matchConditions, when set, is used verbatim as the chain's FilterChainMatch instead
+	// of the sniHosts-derived match below. It lets callers that collapse multiple protocols
+	// onto one wildcard listener (buildSidecarOutboundListeners) discriminate chains on
+	// TransportProtocol/ApplicationProtocols in addition to SNI.
+	matchConditions *listener.FilterChainMatch
+
+	// applicationProtocols populates FilterChainMatch.ApplicationProtocols (ALPN), in addition
+	// to any sniHosts-derived SniDomains. A chain with a non-empty applicationProtocols or
+	// sniHosts causes buildListener to add the tls_inspector listener filter automatically, so
+	// Envoy has observed the negotiated SNI/ALPN before filter chain matching runs.
+	applicationProtocols []string
+
+	// requireClientCertificate, trustedCa, verifySubjectAltNames, and verifyCertificateSpki
+	// build a CommonTlsContext.ValidationContext for mTLS-with-pinning gateways. serverCertificate
+	// and privateKey are inline cert/key DataSource filenames; sdsConfig, when set, replaces them
+	// with an SDS-delivered TlsCertificateSdsSecretConfigs entry instead of inline PEM. These are
+	// assembled into a DownstreamTlsContext by buildListener when tlsContext itself is unset;
+	// tlsContext, when set directly, always takes precedence over all of them.
+	requireClientCertificate bool
+	trustedCa                string
+	verifySubjectAltNames    []string
+	verifyCertificateSpki    []string
+	serverCertificate        string
+	privateKey               string
+	sdsConfig                *auth.SdsSecretConfig
 }
 
 // buildListenerOpts are the options required to build a Listener
@@ -583,15 +987,238 @@ type buildListenerOpts struct {
 	protocol        model.Protocol
 	bindToPort      bool
 	filterChainOpts []*filterChainOpts
+	// listenerFilters are listener-level filters (e.g. tls_inspector, http_inspector) that
+	// run before filter chain matching, so FilterChainMatch can discriminate on what they
+	// detect (TransportProtocol, ApplicationProtocols, ServerNames).
+	listenerFilters []listener.ListenerFilter
+	// defaultFilterChainOpt, when set, becomes Listener.DefaultFilterChain: the catch-all
+	// chain Envoy falls back to when none of filterChainOpts' FilterChainMatch conditions
+	// match (unknown SNI, unrecognized ALPN, or any other unmatched passthrough traffic),
+	// instead of the connection being dropped. It has no FilterChainMatch of its own, so it
+	// never takes part in the precedence ordering filterChainOpts goes through.
+	defaultFilterChainOpt *filterChainOpts
+	// extAuthz, when set, causes marshalFilters (for TCP chains) and buildHTTPConnectionManager
+	// (for HTTP chains) to prepend the envoy.ext_authz network/HTTP filter ahead of every other
+	// filter, pointed at the CDS cluster buildExtAuthzCluster produces for it. Callers populate
+	// it from MeshConfig.ExtAuthz; buildMgmtPortListeners leaves it unset so health checks
+	// aren't subject to the mesh-wide authorization policy.
+	extAuthz *meshconfig.ExtAuthz
 }
 
-func buildHTTPConnectionManager(mesh *meshconfig.MeshConfig, httpOpts *httpListenerOpts, httpFilters []*http_conn.HttpFilter) *http_conn.HttpConnectionManager {
-	filters := append(httpFilters,
-		&http_conn.HttpFilter{Name: xdsutil.CORS},
-		&http_conn.HttpFilter{Name: xdsutil.Fault},
-		&http_conn.HttpFilter{Name: xdsutil.Router},
+// buildFileAccessLog returns the envoy.file_access_log AccessLog for mesh.AccessLogFile,
+// encoded as TEXT (mesh.AccessLogFormat, or Envoy's built-in default when unset) or JSON
+// (mesh.AccessLogFields), and optionally restricted via buildAccessLogFilter. Used by
+// buildHTTPConnectionManager below, and by buildSNIDynamicForwardProxyNetworkFilters for its
+// tcp_proxy filter.
+// TODO: buildInboundNetworkFilters/buildOutboundNetworkFilters (the general network filter chain
+// builders, not present in this package) build their own tcp_proxy filters and should call
+// buildFileAccessLog too once they land, so all TCP listeners get the same logging, not just the
+// SNI dynamic-forward-proxy path.
+func buildFileAccessLog(mesh *meshconfig.MeshConfig) *accesslog.AccessLog {
+	fl := &accesslog.FileAccessLog{
+		Path: mesh.AccessLogFile,
+	}
+
+	switch mesh.AccessLogEncoding {
+	case meshconfig.MeshConfig_JSON:
+		jsonFormat := &google_protobuf.Struct{Fields: make(map[string]*google_protobuf.Value, len(mesh.AccessLogFields))}
+		for field, value := range mesh.AccessLogFields {
+			jsonFormat.Fields[field] = &google_protobuf.Value{Kind: &google_protobuf.Value_StringValue{StringValue: value}}
+		}
+		fl.AccessLogFormat = &accesslog.FileAccessLog_JsonFormat{JsonFormat: jsonFormat}
+	default:
+		if mesh.AccessLogFormat != "" {
+			fl.AccessLogFormat = &accesslog.FileAccessLog_Format{Format: mesh.AccessLogFormat}
+		}
+	}
+
+	return &accesslog.AccessLog{
+		Name:   fileAccessLog,
+		Config: util.MessageToStruct(fl),
+		Filter: buildAccessLogFilter(mesh),
+	}
+}
+
+// buildAccessLogFilter builds the optional AccessLogFilter that restricts which requests
+// buildFileAccessLog's file access log emits, OR-ing together whichever of the mesh-configured
+// filters (minimum status code, minimum duration, runtime-key sampling) are set so a request
+// logs if it matches any one of them. Returns nil (log everything) if none are set.
+func buildAccessLogFilter(mesh *meshconfig.MeshConfig) *accesslog.AccessLogFilter {
+	var filters []*accesslog.AccessLogFilter
+
+	if mesh.AccessLogFilterStatusCode > 0 {
+		filters = append(filters, &accesslog.AccessLogFilter{
+			FilterSpecifier: &accesslog.AccessLogFilter_StatusCodeFilter{
+				StatusCodeFilter: &accesslog.StatusCodeFilter{
+					Comparison: &accesslog.ComparisonFilter{
+						Op:    accesslog.ComparisonFilter_GE,
+						Value: &core.RuntimeUInt32{DefaultValue: uint32(mesh.AccessLogFilterStatusCode)},
+					},
+				},
+			},
+		})
+	}
+
+	if mesh.AccessLogFilterMinDuration != nil {
+		minDurationMillis := uint32(util.GogoDurationToDuration(mesh.AccessLogFilterMinDuration) / time.Millisecond)
+		filters = append(filters, &accesslog.AccessLogFilter{
+			FilterSpecifier: &accesslog.AccessLogFilter_DurationFilter{
+				DurationFilter: &accesslog.DurationFilter{
+					Comparison: &accesslog.ComparisonFilter{
+						Op:    accesslog.ComparisonFilter_GE,
+						Value: &core.RuntimeUInt32{DefaultValue: minDurationMillis},
+					},
+				},
+			},
+		})
+	}
+
+	if mesh.AccessLogFilterRuntimeKey != "" {
+		filters = append(filters, &accesslog.AccessLogFilter{
+			FilterSpecifier: &accesslog.AccessLogFilter_RuntimeFilter{
+				RuntimeFilter: &accesslog.RuntimeFilter{RuntimeKey: mesh.AccessLogFilterRuntimeKey},
+			},
+		})
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	default:
+		return &accesslog.AccessLogFilter{
+			FilterSpecifier: &accesslog.AccessLogFilter_OrFilter{
+				OrFilter: &accesslog.OrFilter{Filters: filters},
+			},
+		}
+	}
+}
+
+// buildGRPCHTTPFilters returns the gRPC-aware HTTP filters (bridge, web, stats) to insert ahead
+// of CORS/Fault/Router when the listener serves a gRPC or gRPC-Web service port, giving Envoy
+// per-method gRPC stats and letting legacy HTTP/1.1 clients talk to a gRPC backend.
+func buildGRPCHTTPFilters(protocol model.Protocol) []*http_conn.HttpFilter {
+	if protocol != model.ProtocolGRPC && protocol != model.ProtocolGRPCWeb {
+		return nil
+	}
+
+	grpcStatsConfig := &grpcstats.FilterConfig{
+		EnableUpstreamStats: true,
+		PerMethodStatSpecifier: &grpcstats.FilterConfig_StatsForAllMethods{
+			StatsForAllMethods: &google_protobuf.BoolValue{Value: true},
+		},
+	}
+
+	return []*http_conn.HttpFilter{
+		{Name: grpcHTTP1BridgeFilter},
+		{Name: grpcWebFilter},
+		{
+			Name:   grpcStatsFilter,
+			Config: util.MessageToStruct(grpcStatsConfig),
+		},
+	}
+}
+
+// httpFilterPhaseOrder fixes the relative order buildHTTPConnectionManager's sort enforces between
+// plugin.HTTPFilterPhase values: AUTHN runs first, CUSTOM_POST_ROUTER last. Within a phase, ties are
+// broken by priority, then by name, so the resulting filter order no longer depends on the order
+// plugins happened to run in or register filters.
+//
+// CUSTOM_POST_ROUTER is accepted here for completeness with the plugin-facing enum, but
+// sortHTTPFilters always rejects a filter contributed in that phase: Envoy's HTTP filter chain
+// terminates at envoy.router, which sortHTTPFilters also requires to sort last, so nothing can
+// validly run after it. Plugins should use CUSTOM_PRE_ROUTER instead.
+var httpFilterPhaseOrder = map[plugin.HTTPFilterPhase]int{
+	plugin.AuthnPhase:            0,
+	plugin.AuthzPhase:            1,
+	plugin.StatsPhase:            2,
+	plugin.CustomPreRouterPhase:  3,
+	plugin.RouterPhase:           4,
+	plugin.CustomPostRouterPhase: 5,
+}
+
+// namedHTTPFilter pairs a built HTTP filter with the phase/priority/name sortHTTPFilters uses to
+// place it in the final chain. name is only used to break ties within a (phase, priority) pair;
+// it has no effect on Envoy's behavior.
+type namedHTTPFilter struct {
+	filter   *http_conn.HttpFilter
+	phase    plugin.HTTPFilterPhase
+	priority int
+	name     string
+}
+
+// sortHTTPFilters orders filters by (phase, priority, name) per httpFilterPhaseOrder and returns
+// the plain filter list buildHTTPConnectionManager hands to Envoy. It errors rather than emitting
+// an HTTP filter chain Envoy would reject or silently misbehave on: exactly one envoy.router filter
+// must be present, and it must sort last, since Envoy stops iterating the HTTP filter chain as soon
+// as the router filter starts the upstream request, so anything sorted after it would never run.
+func sortHTTPFilters(filters []namedHTTPFilter) ([]*http_conn.HttpFilter, error) {
+	for _, f := range filters {
+		if f.phase == plugin.CustomPostRouterPhase {
+			return nil, fmt.Errorf("HTTP filter %q requested phase CUSTOM_POST_ROUTER, which is not supported: "+
+				"no filter can run after envoy.router", f.name)
+		}
+	}
+
+	sort.SliceStable(filters, func(i, j int) bool {
+		pi, pj := httpFilterPhaseOrder[filters[i].phase], httpFilterPhaseOrder[filters[j].phase]
+		if pi != pj {
+			return pi < pj
+		}
+		if filters[i].priority != filters[j].priority {
+			return filters[i].priority < filters[j].priority
+		}
+		return filters[i].name < filters[j].name
+	})
+
+	routerIdx := -1
+	sorted := make([]*http_conn.HttpFilter, len(filters))
+	for i, f := range filters {
+		sorted[i] = f.filter
+		if f.filter.Name == xdsutil.Router {
+			if routerIdx >= 0 {
+				return nil, fmt.Errorf("more than one %s filter in HTTP filter chain", xdsutil.Router)
+			}
+			routerIdx = i
+		}
+	}
+	switch {
+	case routerIdx == -1:
+		return nil, fmt.Errorf("HTTP filter chain is missing the %s filter", xdsutil.Router)
+	case routerIdx != len(sorted)-1:
+		return nil, fmt.Errorf("%s filter must be last in the HTTP filter chain, but sorted to position %d of %d",
+			xdsutil.Router, routerIdx, len(sorted))
+	}
+	return sorted, nil
+}
+
+func buildHTTPConnectionManager(mesh *meshconfig.MeshConfig, extAuthz *meshconfig.ExtAuthz, httpOpts *httpListenerOpts, httpFilters []*plugin.HTTPFilter) (*http_conn.HttpConnectionManager, error) {
+	var named []namedHTTPFilter
+	if extAuthz != nil {
+		named = append(named, namedHTTPFilter{
+			filter: buildExtAuthzHTTPFilter(extAuthz),
+			phase:  plugin.AuthzPhase,
+			name:   extAuthzHTTPFilter,
+		})
+	}
+	for _, f := range httpFilters {
+		named = append(named, namedHTTPFilter{filter: f.Filter, phase: f.Phase, priority: f.Priority, name: f.PluginName})
+	}
+	for i, f := range buildGRPCHTTPFilters(httpOpts.protocol) {
+		named = append(named, namedHTTPFilter{filter: f, phase: plugin.StatsPhase, priority: i * 10, name: f.Name})
+	}
+	named = append(named,
+		namedHTTPFilter{filter: &http_conn.HttpFilter{Name: xdsutil.CORS}, phase: plugin.CustomPreRouterPhase, priority: 0, name: xdsutil.CORS},
+		namedHTTPFilter{filter: &http_conn.HttpFilter{Name: xdsutil.Fault}, phase: plugin.CustomPreRouterPhase, priority: 10, name: xdsutil.Fault},
+		namedHTTPFilter{filter: &http_conn.HttpFilter{Name: xdsutil.Router}, phase: plugin.RouterPhase, name: xdsutil.Router},
 	)
 
+	filters, err := sortHTTPFilters(named)
+	if err != nil {
+		return nil, err
+	}
+
 	refresh := time.Duration(mesh.RdsRefreshDelay.Seconds) * time.Second
 	if refresh == 0 {
 		// envoy crashes if 0. Will go away once we move to v2
@@ -641,16 +1268,7 @@ func buildHTTPConnectionManager(mesh *meshconfig.MeshConfig, httpOpts *httpListe
 	}
 
 	if mesh.AccessLogFile != "" {
-		fl := &accesslog.FileAccessLog{
-			Path: mesh.AccessLogFile,
-		}
-
-		connectionManager.AccessLog = []*accesslog.AccessLog{
-			{
-				Config: util.MessageToStruct(fl),
-				Name:   fileAccessLog,
-			},
-		}
+		connectionManager.AccessLog = []*accesslog.AccessLog{buildFileAccessLog(mesh)}
 	}
 
 	if mesh.EnableTracing {
@@ -664,32 +1282,359 @@ func buildHTTPConnectionManager(mesh *meshconfig.MeshConfig, httpOpts *httpListe
 		connectionManagerJSON, _ := json.MarshalIndent(connectionManager, "  ", "  ")
 		log.Infof("LDS: %s \n", string(connectionManagerJSON))
 	}
-	return connectionManager
+	return connectionManager, nil
 }
 
-// buildListener builds and initializes a Listener proto based on the provided opts. It does not set any filters.
-func buildListener(opts buildListenerOpts) *xdsapi.Listener {
-	filterChains := make([]listener.FilterChain, 0, len(opts.filterChainOpts))
-	for _, chain := range opts.filterChainOpts {
-		var match *listener.FilterChainMatch
-
-		if len(chain.sniHosts) > 0 {
-			fullWildcardFound := false
-			for _, h := range chain.sniHosts {
-				if h == "*" {
-					fullWildcardFound = true
-					// If we have a host with *, it effectively means match anything, i.e.
-					// no SNI based matching for this host.
-					break
-				}
+// ensureListenerFilters adds the named listener filters to l if not already present. Used
+// when two services are collapsed onto the same wildcard listener after the listener was
+// already built, to retroactively enable the protocol detection their filter chain matches
+// depend on.
+func ensureListenerFilters(l *xdsapi.Listener, names ...string) {
+	have := make(map[string]bool, len(l.ListenerFilters))
+	for _, f := range l.ListenerFilters {
+		have[f.Name] = true
+	}
+	for _, name := range names {
+		if !have[name] {
+			l.ListenerFilters = append(l.ListenerFilters, listener.ListenerFilter{Name: name})
+			have[name] = true
+		}
+	}
+}
+
+// resolveFilterChainMatch computes chain's FilterChainMatch: matchConditions verbatim if the
+// caller set it, otherwise a SniDomains match derived from sniHosts (nil - matching anything -
+// if sniHosts is empty or contains the literal "*").
+func resolveFilterChainMatch(chain *filterChainOpts) *listener.FilterChainMatch {
+	if chain.matchConditions != nil {
+		return chain.matchConditions
+	}
+	sniDomains := chain.sniHosts
+	for _, h := range chain.sniHosts {
+		if h == "*" {
+			// If we have a host with *, it effectively means match anything, i.e.
+			// no SNI based matching for this host.
+			sniDomains = nil
+			break
+		}
+	}
+	if len(sniDomains) == 0 && len(chain.applicationProtocols) == 0 {
+		return nil
+	}
+	return &listener.FilterChainMatch{
+		SniDomains:           sniDomains,
+		ApplicationProtocols: chain.applicationProtocols,
+	}
+}
+
+const (
+	serverNameRankExact = iota
+	serverNameRankSuffixWildcard
+	serverNameRankPrefixWildcard
+	serverNameRankAny
+)
+
+// serverNameRank returns the most specific (lowest-ranked) class of server name present in
+// domains, matching the precedence Envoy itself applies when more than one chain could match
+// on SNI: an exact name always wins over any wildcard, a "*.foo.com" suffix wildcard beats a
+// "foo.*" prefix wildcard, and no server names at all (nil/empty) matches anything.
+func serverNameRank(domains []string) int {
+	if len(domains) == 0 {
+		return serverNameRankAny
+	}
+	best := serverNameRankAny
+	for _, d := range domains {
+		switch {
+		case d == "*":
+			// matches anything; doesn't improve on whatever rank we've already found
+		case strings.HasPrefix(d, "*."):
+			if serverNameRankSuffixWildcard < best {
+				best = serverNameRankSuffixWildcard
+			}
+		case strings.HasSuffix(d, ".*"):
+			if serverNameRankPrefixWildcard < best {
+				best = serverNameRankPrefixWildcard
 			}
-			if !fullWildcardFound {
-				match = &listener.FilterChainMatch{SniDomains: chain.sniHosts}
+		default:
+			return serverNameRankExact
+		}
+	}
+	return best
+}
+
+// transportProtocolRank ranks a FilterChainMatch.TransportProtocol value: tls is more specific
+// than raw_buffer, and no value at all (detection not yet run, or irrelevant) is least specific.
+func transportProtocolRank(transportProtocol string) int {
+	switch transportProtocol {
+	case "tls":
+		return 0
+	case transportProtocolRawBuffer:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// sourceTypeRank ranks a FilterChainMatch.SourceType value: a connection from the same host
+// (loopback or a shared IP, e.g. another container in the same pod) is more specific than one
+// merely known to originate outside the proxy's host, which in turn is more specific than "any".
+func sourceTypeRank(sourceType listener.FilterChainMatch_ConnectionSourceType) int {
+	switch sourceType {
+	case listener.FilterChainMatch_SAME_IP_OR_LOOPBACK:
+		return 0
+	case listener.FilterChainMatch_EXTERNAL:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// maxPrefixLen returns the longest PrefixLen among ranges, or 0 (least specific - matches any
+// address) if ranges is empty.
+func maxPrefixLen(ranges []*core.CidrRange) int {
+	max := 0
+	for _, r := range ranges {
+		if pl := int(r.GetPrefixLen().GetValue()); pl > max {
+			max = pl
+		}
+	}
+	return max
+}
+
+// filterChainPrecedence is a sortable tuple capturing how specifically a FilterChainMatch
+// constrains a connection: most-specific destination prefix first, then destination port, then
+// server names, then transport protocol, then ALPN, then source type, then source prefix, then
+// source port. This is the precedence order this package resolves overlapping chains with; it
+// is not a guarantee that it is evaluated in the same field order as Envoy's own implementation.
+// Two chains that resolve to an identical tuple are ambiguous under this ordering, so
+// buildListener rejects that case outright instead of leaving it to a stable sort.
+type filterChainPrecedence struct {
+	destinationPrefixLen   int
+	hasDestinationPort     bool
+	destinationPort        uint32
+	serverNameRank         int
+	transportProtocolRank  int
+	hasApplicationProtocol bool
+	sourceTypeRank         int
+	sourcePrefixLen        int
+	hasSourcePort          bool
+	sourcePort             uint32
+}
+
+func filterChainMatchPrecedence(match *listener.FilterChainMatch) filterChainPrecedence {
+	key := filterChainPrecedence{
+		serverNameRank:        serverNameRankAny,
+		transportProtocolRank: transportProtocolRank(""),
+		sourceTypeRank:        sourceTypeRank(listener.FilterChainMatch_ANY),
+	}
+	if match == nil {
+		return key
+	}
+
+	key.destinationPrefixLen = maxPrefixLen(match.PrefixRanges)
+	if match.DestinationPort != nil {
+		key.hasDestinationPort = true
+		key.destinationPort = match.DestinationPort.Value
+	}
+	key.serverNameRank = serverNameRank(match.SniDomains)
+	key.transportProtocolRank = transportProtocolRank(match.TransportProtocol)
+	key.hasApplicationProtocol = len(match.ApplicationProtocols) > 0
+	key.sourceTypeRank = sourceTypeRank(match.SourceType)
+	key.sourcePrefixLen = maxPrefixLen(match.SourcePrefixRanges)
+	if len(match.SourcePorts) > 0 {
+		key.hasSourcePort = true
+		key.sourcePort = match.SourcePorts[0]
+	}
+	return key
+}
+
+// less reports whether k should be evaluated before other: more specific destination prefix
+// first, then destination port, then server name, transport protocol, ALPN, source type, source
+// prefix, and finally source port (see filterChainPrecedence's doc comment for the caveat on
+// how this relates to Envoy's own match evaluation).
+func (k filterChainPrecedence) less(other filterChainPrecedence) bool {
+	if k.destinationPrefixLen != other.destinationPrefixLen {
+		return k.destinationPrefixLen > other.destinationPrefixLen
+	}
+	if k.hasDestinationPort != other.hasDestinationPort {
+		return k.hasDestinationPort
+	}
+	if k.destinationPort != other.destinationPort {
+		return k.destinationPort < other.destinationPort
+	}
+	if k.serverNameRank != other.serverNameRank {
+		return k.serverNameRank < other.serverNameRank
+	}
+	if k.transportProtocolRank != other.transportProtocolRank {
+		return k.transportProtocolRank < other.transportProtocolRank
+	}
+	if k.hasApplicationProtocol != other.hasApplicationProtocol {
+		return k.hasApplicationProtocol
+	}
+	if k.sourceTypeRank != other.sourceTypeRank {
+		return k.sourceTypeRank < other.sourceTypeRank
+	}
+	if k.sourcePrefixLen != other.sourcePrefixLen {
+		return k.sourcePrefixLen > other.sourcePrefixLen
+	}
+	if k.hasSourcePort != other.hasSourcePort {
+		return k.hasSourcePort
+	}
+	return k.sourcePort < other.sourcePort
+}
+
+// filterChainPrecedenceOrder returns the permutation of indices into matches that sorts them
+// into Envoy's own filter-chain match precedence, and rejects the listener outright if two
+// chains resolve to an identical precedence tuple, since Envoy itself would have no
+// deterministic way to pick between them.
+func filterChainPrecedenceOrder(matches []*listener.FilterChainMatch) ([]int, error) {
+	order := make([]int, len(matches))
+	keys := make([]filterChainPrecedence, len(matches))
+	for i := range matches {
+		order[i] = i
+		keys[i] = filterChainMatchPrecedence(matches[i])
+	}
+
+	sort.SliceStable(order, func(a, b int) bool { return keys[order[a]].less(keys[order[b]]) })
+
+	for i := 1; i < len(order); i++ {
+		if keys[order[i]] == keys[order[i-1]] {
+			return nil, fmt.Errorf("ambiguous filter chain match: chains at index %d and %d resolve to the same match precedence",
+				order[i-1], order[i])
+		}
+	}
+	return order, nil
+}
+
+// sortFilterChainsByPrecedence reorders opts (in place - callers keep indexing the same backing
+// array for the subsequent plugin loop and marshalFilters call) and matches in lockstep into
+// Envoy's own filter-chain match precedence, and rejects the listener outright if two chains
+// resolve to an identical precedence tuple.
+func sortFilterChainsByPrecedence(opts []*filterChainOpts, matches []*listener.FilterChainMatch) error {
+	order, err := filterChainPrecedenceOrder(matches)
+	if err != nil {
+		return err
+	}
+
+	sortedOpts := make([]*filterChainOpts, len(opts))
+	sortedMatches := make([]*listener.FilterChainMatch, len(matches))
+	for i, idx := range order {
+		sortedOpts[i] = opts[idx]
+		sortedMatches[i] = matches[idx]
+	}
+	copy(opts, sortedOpts)
+	copy(matches, sortedMatches)
+	return nil
+}
+
+// sortVirtualOutboundFilterChains reorders chains (in place) into the same precedence
+// buildListener enforces for a single service's listener, so the many per-service chains
+// buildVirtualOutboundListener collapses onto one virtual listener don't produce ambiguous,
+// nondeterministically-resolved FilterChainMatch entries once they all share a listener.
+func sortVirtualOutboundFilterChains(chains []listener.FilterChain) error {
+	matches := make([]*listener.FilterChainMatch, len(chains))
+	for i := range chains {
+		matches[i] = chains[i].FilterChainMatch
+	}
+
+	order, err := filterChainPrecedenceOrder(matches)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]listener.FilterChain, len(chains))
+	for i, idx := range order {
+		sorted[i] = chains[idx]
+	}
+	copy(chains, sorted)
+	return nil
+}
+
+// buildFilterChainTlsContext assembles chain's DownstreamTlsContext from its higher-level TLS
+// knobs (requireClientCertificate, trustedCa, verifySubjectAltNames, verifyCertificateSpki,
+// serverCertificate/privateKey or sdsConfig, applicationProtocols) when chain.tlsContext itself
+// isn't already set. chain.tlsContext, when set directly, is always returned as-is. It errors
+// out rather than silently emitting a DownstreamTlsContext Envoy would reject or that wouldn't
+// enforce what it claims to: a certificate source is required as soon as any of these knobs is
+// used, and requireClientCertificate requires a validation context to actually check against.
+func buildFilterChainTlsContext(chain *filterChainOpts) (*auth.DownstreamTlsContext, error) {
+	if chain.tlsContext != nil {
+		return chain.tlsContext, nil
+	}
+
+	hasValidation := chain.trustedCa != "" || len(chain.verifySubjectAltNames) > 0 || len(chain.verifyCertificateSpki) > 0
+	hasCertificateSource := chain.serverCertificate != "" || chain.sdsConfig != nil
+	if !chain.requireClientCertificate && !hasValidation && !hasCertificateSource && len(chain.applicationProtocols) == 0 {
+		return nil, nil
+	}
+	if !hasCertificateSource {
+		return nil, fmt.Errorf("filter chain TLS settings require a server certificate: set serverCertificate/privateKey or sdsConfig")
+	}
+	if chain.requireClientCertificate && !hasValidation {
+		return nil, fmt.Errorf("filter chain requires a client certificate but has no validation context: set trustedCa, verifySubjectAltNames, or verifyCertificateSpki")
+	}
+
+	var validationContext *auth.CertificateValidationContext
+	if hasValidation {
+		validationContext = &auth.CertificateValidationContext{
+			VerifySubjectAltName:  chain.verifySubjectAltNames,
+			VerifyCertificateSpki: chain.verifyCertificateSpki,
+		}
+		if chain.trustedCa != "" {
+			validationContext.TrustedCa = &core.DataSource{
+				Specifier: &core.DataSource_Filename{Filename: chain.trustedCa},
 			}
 		}
+	}
+
+	commonTLSContext := &auth.CommonTlsContext{
+		ValidationContext: validationContext,
+		AlpnProtocols:     chain.applicationProtocols,
+	}
+	if chain.sdsConfig != nil {
+		commonTLSContext.TlsCertificateSdsSecretConfigs = []*auth.SdsSecretConfig{chain.sdsConfig}
+	} else {
+		commonTLSContext.TlsCertificates = []*auth.TlsCertificate{
+			{
+				CertificateChain: &core.DataSource{Specifier: &core.DataSource_Filename{Filename: chain.serverCertificate}},
+				PrivateKey:       &core.DataSource{Specifier: &core.DataSource_Filename{Filename: chain.privateKey}},
+			},
+		}
+	}
+
+	downstreamTLSContext := &auth.DownstreamTlsContext{CommonTlsContext: commonTLSContext}
+	if chain.requireClientCertificate {
+		downstreamTLSContext.RequireClientCertificate = &google_protobuf.BoolValue{Value: true}
+	}
+	return downstreamTLSContext, nil
+}
+
+// buildListener builds and initializes a Listener proto based on the provided opts. It does not set any filters.
+func buildListener(opts buildListenerOpts) (*xdsapi.Listener, error) {
+	matches := make([]*listener.FilterChainMatch, len(opts.filterChainOpts))
+	for i, chain := range opts.filterChainOpts {
+		matches[i] = resolveFilterChainMatch(chain)
+	}
+
+	if len(opts.filterChainOpts) > 1 {
+		if err := sortFilterChainsByPrecedence(opts.filterChainOpts, matches); err != nil {
+			return nil, err
+		}
+	}
+
+	needsTLSInspector := false
+	filterChains := make([]listener.FilterChain, 0, len(opts.filterChainOpts))
+	for i, chain := range opts.filterChainOpts {
+		if matches[i] != nil && (len(matches[i].SniDomains) > 0 || len(matches[i].ApplicationProtocols) > 0) {
+			needsTLSInspector = true
+		}
+		tlsContext, err := buildFilterChainTlsContext(chain)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s_%d filter chain %d: %v", opts.ip, opts.port, i, err)
+		}
 		filterChains = append(filterChains, listener.FilterChain{
-			FilterChainMatch: match,
-			TlsContext:       chain.tlsContext,
+			FilterChainMatch: matches[i],
+			TlsContext:       tlsContext,
 		})
 	}
 
@@ -700,12 +1645,26 @@ func buildListener(opts buildListenerOpts) *xdsapi.Listener {
 		}
 	}
 
-	return &xdsapi.Listener{
-		Name:         fmt.Sprintf("%s_%d", opts.ip, opts.port),
-		Address:      util.BuildAddress(opts.ip, uint32(opts.port)),
-		FilterChains: filterChains,
-		DeprecatedV1: deprecatedV1,
+	l := &xdsapi.Listener{
+		Name:            fmt.Sprintf("%s_%d", opts.ip, opts.port),
+		Address:         util.BuildAddress(opts.ip, uint32(opts.port)),
+		FilterChains:    filterChains,
+		ListenerFilters: opts.listenerFilters,
+		DeprecatedV1:    deprecatedV1,
 	}
+	if needsTLSInspector {
+		ensureListenerFilters(l, tlsInspectorListenerFilter)
+	}
+	if opts.defaultFilterChainOpt != nil {
+		tlsContext, err := buildFilterChainTlsContext(opts.defaultFilterChainOpt)
+		if err != nil {
+			return nil, fmt.Errorf("listener %s_%d default filter chain: %v", opts.ip, opts.port, err)
+		}
+		l.DefaultFilterChain = &listener.FilterChain{
+			TlsContext: tlsContext,
+		}
+	}
+	return l, nil
 }
 
 // marshalFilters adds the provided TCP and HTTP filters to the provided Listener and serializes them.
@@ -715,19 +1674,27 @@ func buildListener(opts buildListenerOpts) *xdsapi.Listener {
 // we should encapsulate them some way to ensure they remain consistent (mainly that in each an index refers to the same
 // chain)
 func marshalFilters(l *xdsapi.Listener, opts buildListenerOpts, chains []plugin.FilterChain) error {
-	if len(opts.filterChainOpts) == 0 {
+	if len(opts.filterChainOpts) == 0 && opts.defaultFilterChainOpt == nil {
 		return fmt.Errorf("must have more than 0 chains in listener: %#v", l)
 	}
 
-	for i, chain := range chains {
+	for i := 0; i < len(opts.filterChainOpts); i++ {
+		chain := chains[i]
 		opt := opts.filterChainOpts[i]
 		// check that we either have all TCP or all HTTP chain, and not a mix
 		// TODO: remove when Envoy supports port protocol multiplexing
-		if (len(chain.TCP) > 0 || len(opt.networkFilters) > 0) && (len(chain.HTTP) > 0 || opt.httpOpts != nil) {
+		//
+		// opt.networkFilters is deliberately excluded from this check: it can carry filters
+		// like connection_limit that are meant to run ahead of an HTTP chain too, not just
+		// ahead of plugin-contributed TCP filters.
+		if len(chain.TCP) > 0 && (len(chain.HTTP) > 0 || opt.httpOpts != nil) {
 			return fmt.Errorf("listener %q filter chain %d cannot set both network(%#v) and HTTP(%#v) filter chains",
-				l.Name, i, append(chain.TCP, opt.networkFilters...), chain.HTTP)
+				l.Name, i, chain.TCP, chain.HTTP)
 		}
 
+		if wantsExtAuthzNetworkFilter(opts.extAuthz) && opt.httpOpts == nil {
+			l.FilterChains[i].Filters = append(l.FilterChains[i].Filters, buildExtAuthzNetworkFilter(opts.extAuthz))
+		}
 		l.FilterChains[i].Filters = append(l.FilterChains[i].Filters, chain.TCP...)
 		l.FilterChains[i].Filters = append(l.FilterChains[i].Filters, opt.networkFilters...)
 		if log.DebugEnabled() {
@@ -735,7 +1702,10 @@ func marshalFilters(l *xdsapi.Listener, opts buildListenerOpts, chains []plugin.
 		}
 
 		if opt.httpOpts != nil {
-			connectionManager := buildHTTPConnectionManager(opts.env.Mesh, opt.httpOpts, chain.HTTP)
+			connectionManager, err := buildHTTPConnectionManager(opts.env.Mesh, opts.extAuthz, opt.httpOpts, chain.HTTP)
+			if err != nil {
+				return fmt.Errorf("listener %q filter chain %d: %v", l.Name, i, err)
+			}
 			l.FilterChains[i].Filters = append(l.FilterChains[i].Filters, listener.Filter{
 				Name:   envoyHTTPConnectionManager,
 				Config: util.MessageToStruct(connectionManager),
@@ -743,5 +1713,42 @@ func marshalFilters(l *xdsapi.Listener, opts buildListenerOpts, chains []plugin.
 			log.Debugf("attached HTTP filter with %d http_filter options to listener %q filter chain %d", 1+len(chain.HTTP), l.Name, i)
 		}
 	}
+
+	// A chains entry past filterChainOpts, when present, carries the plugin-contributed
+	// TCP/HTTP filters for DefaultFilterChain, the catch-all Envoy falls back to when no
+	// FilterChainMatch matches (see buildListenerOpts.defaultFilterChainOpt). Callers that
+	// want plugins to see the default chain must size chains to len(opts.filterChainOpts)+1;
+	// opt.networkFilters/opt.httpOpts are applied either way since those come from config,
+	// not from the plugin loop.
+	if opts.defaultFilterChainOpt != nil {
+		if l.DefaultFilterChain == nil {
+			return fmt.Errorf("listener %q requested a default filter chain but none was built", l.Name)
+		}
+		var chain plugin.FilterChain
+		if len(chains) > len(opts.filterChainOpts) {
+			chain = chains[len(opts.filterChainOpts)]
+		}
+		opt := opts.defaultFilterChainOpt
+		if len(chain.TCP) > 0 && (len(chain.HTTP) > 0 || opt.httpOpts != nil) {
+			return fmt.Errorf("listener %q default filter chain cannot set both network(%#v) and HTTP(%#v) filter chains",
+				l.Name, chain.TCP, chain.HTTP)
+		}
+
+		if wantsExtAuthzNetworkFilter(opts.extAuthz) && opt.httpOpts == nil {
+			l.DefaultFilterChain.Filters = append(l.DefaultFilterChain.Filters, buildExtAuthzNetworkFilter(opts.extAuthz))
+		}
+		l.DefaultFilterChain.Filters = append(l.DefaultFilterChain.Filters, chain.TCP...)
+		l.DefaultFilterChain.Filters = append(l.DefaultFilterChain.Filters, opt.networkFilters...)
+		if opt.httpOpts != nil {
+			connectionManager, err := buildHTTPConnectionManager(opts.env.Mesh, opts.extAuthz, opt.httpOpts, chain.HTTP)
+			if err != nil {
+				return fmt.Errorf("listener %q default filter chain: %v", l.Name, err)
+			}
+			l.DefaultFilterChain.Filters = append(l.DefaultFilterChain.Filters, listener.Filter{
+				Name:   envoyHTTPConnectionManager,
+				Config: util.MessageToStruct(connectionManager),
+			})
+		}
+	}
 	return nil
 }
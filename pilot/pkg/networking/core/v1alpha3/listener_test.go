@@ -0,0 +1,185 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	accesslog "github.com/envoyproxy/go-control-plane/envoy/config/filter/accesslog/v2"
+	tcp_proxy "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+	xdsutil "github.com/envoyproxy/go-control-plane/pkg/util"
+	"github.com/gogo/protobuf/jsonpb"
+	google_protobuf "github.com/gogo/protobuf/types"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pilot/pkg/networking/util"
+)
+
+// TestBuildSNIDynamicForwardProxyNetworkFiltersAccessLog exercises the tcp_proxy filter's
+// AccessLog wiring end to end: a mesh with AccessLogFile set must produce a tcp_proxy filter
+// whose AccessLog is populated via buildFileAccessLog, and a mesh with it unset must leave
+// AccessLog empty rather than emitting an empty/zero-value entry.
+func TestBuildSNIDynamicForwardProxyNetworkFiltersAccessLog(t *testing.T) {
+	service := &model.Service{Hostname: model.Hostname("wildcard.example.com")}
+	servicePort := &model.Port{Port: 443, Protocol: model.ProtocolHTTPS}
+
+	cases := []struct {
+		name        string
+		accessLog   string
+		wantEntries int
+	}{
+		{name: "access log configured", accessLog: "/dev/stdout", wantEntries: 1},
+		{name: "access log unset", accessLog: "", wantEntries: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mesh := &meshconfig.MeshConfig{AccessLogFile: c.accessLog}
+
+			filters := buildSNIDynamicForwardProxyNetworkFilters(mesh, service, servicePort)
+
+			var tcpProxyFilter *tcp_proxy.TcpProxy
+			for _, f := range filters {
+				if f.Name != xdsutil.TCPProxy {
+					continue
+				}
+				tcpProxyFilter = &tcp_proxy.TcpProxy{}
+				if err := google_protobuf.UnmarshalAny(f.GetTypedConfig(), tcpProxyFilter); err != nil {
+					t.Fatalf("failed to unmarshal tcp_proxy filter: %v", err)
+				}
+			}
+			if tcpProxyFilter == nil {
+				t.Fatalf("buildSNIDynamicForwardProxyNetworkFilters did not return a %s filter", xdsutil.TCPProxy)
+			}
+
+			if got := len(tcpProxyFilter.AccessLog); got != c.wantEntries {
+				t.Errorf("tcp_proxy AccessLog entries = %d, want %d", got, c.wantEntries)
+			}
+		})
+	}
+}
+
+// TestBuildVirtualOutboundListenerRouteEquivalence verifies that collapsing per-service
+// outbound listeners onto the single original_dst-matched virtual listener
+// (buildVirtualOutboundListener) doesn't change what a connection resolves to: a request
+// captured to a known service's VIP:port must still reach that service's own filters
+// (standing in for its route table here, since there's no route.go in this package to build
+// a real RouteConfiguration from), not some other service's or nothing at all.
+func TestBuildVirtualOutboundListenerRouteEquivalence(t *testing.T) {
+	mesh := &meshconfig.MeshConfig{ProxyListenPort: 15001}
+	node := model.Proxy{Metadata: map[string]string{}}
+
+	services := []struct {
+		name    string
+		address string
+		port    uint32
+	}{
+		{name: "foo.default.svc.cluster.local", address: "10.0.0.1", port: 80},
+		{name: "bar.default.svc.cluster.local", address: "10.0.0.2", port: 9080},
+	}
+
+	legacy := make([]*xdsapi.Listener, len(services))
+	for i, svc := range services {
+		legacy[i] = &xdsapi.Listener{
+			Name:    svc.name,
+			Address: util.BuildAddress(svc.address, svc.port),
+			FilterChains: []listener.FilterChain{
+				{
+					Filters: []listener.Filter{
+						{
+							Name: xdsutil.TCPProxy,
+							ConfigType: &listener.Filter_TypedConfig{TypedConfig: util.MessageToAny(&tcp_proxy.TcpProxy{
+								StatPrefix:       svc.name,
+								ClusterSpecifier: &tcp_proxy.TcpProxy_Cluster{Cluster: "outbound|" + svc.name},
+							})},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	virtual, err := buildVirtualOutboundListener(mesh, node, legacy)
+	if err != nil {
+		t.Fatalf("buildVirtualOutboundListener: %v", err)
+	}
+
+	for i, svc := range services {
+		var matched *listener.FilterChain
+		for j := range virtual.FilterChains {
+			match := virtual.FilterChains[j].FilterChainMatch
+			if match == nil || match.DestinationPort == nil || match.DestinationPort.Value != svc.port {
+				continue
+			}
+			for _, cidr := range match.PrefixRanges {
+				if cidr.AddressPrefix == svc.address {
+					matched = &virtual.FilterChains[j]
+				}
+			}
+		}
+		if matched == nil {
+			t.Fatalf("no filter chain in the virtual listener matches a request captured to %s:%d", svc.address, svc.port)
+		}
+		if !reflect.DeepEqual(matched.Filters, legacy[i].FilterChains[0].Filters) {
+			t.Errorf("%s: virtual listener filters = %v, want %v (legacy per-service layout)",
+				svc.name, matched.Filters, legacy[i].FilterChains[0].Filters)
+		}
+	}
+}
+
+// TestBuildFileAccessLogJSONFields exercises buildFileAccessLog's JSON encoding and
+// buildAccessLogFilter's status-code filtering together, analogous to Consul's access-log
+// integration test that curls a workload and greps the emitted JSON for %REQ(:PATH)% and
+// %RESPONSE_CODE%: this package has no e2e harness to actually run Envoy and curl a workload
+// through it, so this greps the JSON FileAccessLog config buildHTTPConnectionManager would hand
+// Envoy for those same operators, and checks the accompanying filter only admits the status
+// codes it's configured to.
+func TestBuildFileAccessLogJSONFields(t *testing.T) {
+	mesh := &meshconfig.MeshConfig{
+		AccessLogFile:     "/dev/stdout",
+		AccessLogEncoding: meshconfig.MeshConfig_JSON,
+		AccessLogFields: map[string]string{
+			"path":          "%REQ(:PATH)%",
+			"response_code": "%RESPONSE_CODE%",
+		},
+		AccessLogFilterStatusCode: 400,
+	}
+
+	al := buildFileAccessLog(mesh)
+
+	marshaler := jsonpb.Marshaler{}
+	got, err := marshaler.MarshalToString(al.Config)
+	if err != nil {
+		t.Fatalf("marshaling FileAccessLog config: %v", err)
+	}
+	for _, want := range []string{"%REQ(:PATH)%", "%RESPONSE_CODE%"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("FileAccessLog JSON config = %s, want it to contain %q", got, want)
+		}
+	}
+
+	statusFilter := al.Filter.GetStatusCodeFilter()
+	if statusFilter == nil {
+		t.Fatalf("buildAccessLogFilter did not produce a StatusCodeFilter for AccessLogFilterStatusCode=400")
+	}
+	if statusFilter.Comparison.Op != accesslog.ComparisonFilter_GE || statusFilter.Comparison.Value.DefaultValue != 400 {
+		t.Errorf("StatusCodeFilter = %+v, want op GE, value 400", statusFilter)
+	}
+}
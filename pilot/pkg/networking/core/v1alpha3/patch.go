@@ -0,0 +1,443 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"fmt"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/istio/pilot/pkg/networking/util"
+	"istio.io/istio/pkg/log"
+)
+
+// PatchContext identifies which listener-building code path a ListenerPatch applies to, mirroring
+// the three contexts an EnvoyFilter resource can target. A patch whose Context doesn't match the
+// listener currently being built is skipped entirely.
+type PatchContext int
+
+const (
+	PatchContextSidecarInbound PatchContext = iota
+	PatchContextSidecarOutbound
+	PatchContextGateway
+)
+
+// PatchOperation is the mutation ListenerPatch.Operation requests.
+type PatchOperation int
+
+const (
+	PatchAdd PatchOperation = iota
+	PatchRemove
+	PatchInsertBefore
+	PatchInsertAfter
+	PatchMerge
+)
+
+// PatchTarget is the level of the listener ListenerPatch.Operation acts on.
+type PatchTarget int
+
+const (
+	PatchTargetListener PatchTarget = iota
+	PatchTargetFilterChain
+	PatchTargetNetworkFilter
+	PatchTargetHTTPFilter
+)
+
+// ListenerMatch selects the listener (and, for filter chain/filter targets, the filter chain
+// within it) a ListenerPatch applies to. A zero-value field is treated as a wildcard: an empty
+// Name or PortNumber==0 matches any listener, and an empty FilterChainSNI/FilterChainTransportProtocol
+// or empty FilterChainApplicationProtocols matches any filter chain.
+type ListenerMatch struct {
+	Name                            string
+	PortNumber                      uint32
+	FilterChainSNI                  string
+	FilterChainTransportProtocol    string
+	FilterChainApplicationProtocols []string
+}
+
+// ListenerPatch is the in-memory form of a single EnvoyFilter patch rule, already resolved to the
+// proxy it applies to (workload selector matching happens upstream of this package, the same way
+// env.DestinationRule resolves a single winning config for a hostname before this package ever
+// sees it). Value holds the proto to add/merge and must match Target: a *listener.Filter for
+// PatchTargetNetworkFilter, a *http_conn.HttpFilter for PatchTargetHTTPFilter, a *listener.FilterChain
+// for PatchTargetFilterChain, or a *xdsapi.Listener for PatchTargetListener. Value is ignored for
+// PatchRemove.
+type ListenerPatch struct {
+	Context    PatchContext
+	Match      ListenerMatch
+	Operation  PatchOperation
+	Target     PatchTarget
+	FilterName string
+	Value      interface{}
+}
+
+var patchApplications = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "pilot_envoy_filter_patches",
+	Help: "Number of EnvoyFilter listener patches applied, partitioned by whether they matched anything.",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(patchApplications)
+}
+
+// applyListenerPatches applies every patch in patches whose Context matches ctx to l, in order,
+// and returns the patched listener. It returns nil if a PatchTargetListener PatchRemove patch
+// matches, signalling to the caller that the entire listener should be dropped. A network or HTTP
+// filter removal that empties a filter chain's filter list drops that chain rather than leaving it
+// in the listener, since an Envoy filter chain with no filters is invalid.
+func applyListenerPatches(ctx PatchContext, patches []*ListenerPatch, l *xdsapi.Listener) *xdsapi.Listener {
+	for _, p := range patches {
+		if p.Context != ctx {
+			continue
+		}
+		if !listenerMatches(p.Match, l) {
+			patchApplications.WithLabelValues("not_applied").Inc()
+			continue
+		}
+
+		var err error
+		switch p.Target {
+		case PatchTargetListener:
+			if p.Operation == PatchRemove {
+				patchApplications.WithLabelValues("applied").Inc()
+				return nil
+			}
+			l, err = applyListenerTargetPatch(p, l)
+		case PatchTargetFilterChain:
+			err = applyFilterChainPatch(p, l)
+		case PatchTargetNetworkFilter:
+			err = applyNetworkFilterPatch(p, l)
+		case PatchTargetHTTPFilter:
+			err = applyHTTPFilterPatch(p, l)
+		}
+		if err != nil {
+			log.Warnf("applyListenerPatches: skipping patch on listener %s: %v", l.Name, err)
+			patchApplications.WithLabelValues("not_applied").Inc()
+			continue
+		}
+		patchApplications.WithLabelValues("applied").Inc()
+	}
+
+	dropEmptyFilterChains(l)
+	return l
+}
+
+// listenerMatches reports whether match selects l itself. It does not consider the
+// FilterChain* fields of match; those are evaluated per filter chain by filterChainMatches.
+func listenerMatches(match ListenerMatch, l *xdsapi.Listener) bool {
+	if match.Name != "" && match.Name != l.Name {
+		return false
+	}
+	if match.PortNumber != 0 {
+		if addr := l.Address.GetSocketAddress(); addr == nil || addr.GetPortValue() != match.PortNumber {
+			return false
+		}
+	}
+	return true
+}
+
+// filterChainMatches reports whether match's FilterChain* fields select chain. Listener-level
+// fields (Name, PortNumber) are assumed already checked by listenerMatches.
+func filterChainMatches(match ListenerMatch, chain *listener.FilterChain) bool {
+	fcm := chain.FilterChainMatch
+	if match.FilterChainSNI != "" {
+		if fcm == nil || !containsString(fcm.SniDomains, match.FilterChainSNI) {
+			return false
+		}
+	}
+	if match.FilterChainTransportProtocol != "" {
+		if fcm == nil || fcm.TransportProtocol != match.FilterChainTransportProtocol {
+			return false
+		}
+	}
+	if len(match.FilterChainApplicationProtocols) > 0 {
+		if fcm == nil || !containsAny(fcm.ApplicationProtocols, match.FilterChainApplicationProtocols) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, h := range haystack {
+		if h == needle {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(haystack, needles []string) bool {
+	for _, n := range needles {
+		if containsString(haystack, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyListenerTargetPatch handles PatchAdd/PatchMerge against the whole listener; PatchRemove is
+// handled by the caller since it short-circuits the rest of the patch loop.
+func applyListenerTargetPatch(p *ListenerPatch, l *xdsapi.Listener) (*xdsapi.Listener, error) {
+	patch, ok := p.Value.(*xdsapi.Listener)
+	if !ok {
+		return l, fmt.Errorf("listener patch value must be a *xdsapi.Listener, got %T", p.Value)
+	}
+	switch p.Operation {
+	case PatchMerge:
+		proto.Merge(l, patch)
+		return l, nil
+	case PatchAdd:
+		// Nothing to add a new listener to here: an ADD at the listener level only makes sense
+		// when no listener of this name exists yet, which applyListenerPatches can't express since
+		// it's always handed an already-built listener. Treat it as a no-op merge instead of
+		// silently dropping the caller's patch.
+		proto.Merge(l, patch)
+		return l, nil
+	default:
+		return l, fmt.Errorf("unsupported operation %v for PatchTargetListener", p.Operation)
+	}
+}
+
+func applyFilterChainPatch(p *ListenerPatch, l *xdsapi.Listener) error {
+	patch, ok := p.Value.(*listener.FilterChain)
+	if !ok {
+		return fmt.Errorf("filter chain patch value must be a *listener.FilterChain, got %T", p.Value)
+	}
+
+	idx := -1
+	for i := range l.FilterChains {
+		if filterChainMatches(p.Match, &l.FilterChains[i]) {
+			idx = i
+			break
+		}
+	}
+
+	switch p.Operation {
+	case PatchAdd:
+		l.FilterChains = append(l.FilterChains, cloneFilterChain(patch))
+	case PatchRemove:
+		if idx < 0 {
+			return fmt.Errorf("no filter chain matched %+v", p.Match)
+		}
+		l.FilterChains = append(l.FilterChains[:idx], l.FilterChains[idx+1:]...)
+	case PatchInsertBefore:
+		if idx < 0 {
+			return fmt.Errorf("no filter chain matched %+v", p.Match)
+		}
+		l.FilterChains = insertFilterChain(l.FilterChains, idx, cloneFilterChain(patch))
+	case PatchInsertAfter:
+		if idx < 0 {
+			return fmt.Errorf("no filter chain matched %+v", p.Match)
+		}
+		l.FilterChains = insertFilterChain(l.FilterChains, idx+1, cloneFilterChain(patch))
+	case PatchMerge:
+		if idx < 0 {
+			return fmt.Errorf("no filter chain matched %+v", p.Match)
+		}
+		proto.Merge(&l.FilterChains[idx], patch)
+	default:
+		return fmt.Errorf("unsupported operation %v for PatchTargetFilterChain", p.Operation)
+	}
+	return nil
+}
+
+func insertFilterChain(chains []listener.FilterChain, at int, chain listener.FilterChain) []listener.FilterChain {
+	chains = append(chains, listener.FilterChain{})
+	copy(chains[at+1:], chains[at:])
+	chains[at] = chain
+	return chains
+}
+
+// cloneFilterChain, cloneNetworkFilter, and cloneHTTPFilter deep-copy a patch's Value before it's
+// woven into a listener. The same *ListenerPatch is applied across every listener a build pass
+// produces (e.g. outboundPatches in buildSidecarOutboundListeners), so storing the literal pointer
+// would let an unrelated later patch on one listener mutate another listener's filters through the
+// shared backing object.
+func cloneFilterChain(patch *listener.FilterChain) listener.FilterChain {
+	return *proto.Clone(patch).(*listener.FilterChain)
+}
+
+func cloneNetworkFilter(patch *listener.Filter) listener.Filter {
+	return *proto.Clone(patch).(*listener.Filter)
+}
+
+func cloneHTTPFilter(patch *http_conn.HttpFilter) *http_conn.HttpFilter {
+	return proto.Clone(patch).(*http_conn.HttpFilter)
+}
+
+// applyNetworkFilterPatch applies p to the Filters list of every filter chain that matches
+// p.Match. FilterName identifies the existing filter for REMOVE/INSERT_BEFORE/INSERT_AFTER/MERGE.
+func applyNetworkFilterPatch(p *ListenerPatch, l *xdsapi.Listener) error {
+	patch, _ := p.Value.(*listener.Filter)
+	if p.Operation != PatchRemove && patch == nil {
+		return fmt.Errorf("network filter patch value must be a *listener.Filter, got %T", p.Value)
+	}
+
+	applied := false
+	for i := range l.FilterChains {
+		chain := &l.FilterChains[i]
+		if !filterChainMatches(p.Match, chain) {
+			continue
+		}
+		idx := networkFilterIndex(chain.Filters, p.FilterName)
+
+		switch p.Operation {
+		case PatchAdd:
+			chain.Filters = append(chain.Filters, cloneNetworkFilter(patch))
+		case PatchRemove:
+			if idx < 0 {
+				continue
+			}
+			chain.Filters = append(chain.Filters[:idx], chain.Filters[idx+1:]...)
+		case PatchInsertBefore:
+			if idx < 0 {
+				continue
+			}
+			chain.Filters = insertNetworkFilter(chain.Filters, idx, cloneNetworkFilter(patch))
+		case PatchInsertAfter:
+			if idx < 0 {
+				continue
+			}
+			chain.Filters = insertNetworkFilter(chain.Filters, idx+1, cloneNetworkFilter(patch))
+		case PatchMerge:
+			if idx < 0 {
+				continue
+			}
+			proto.Merge(&chain.Filters[idx], patch)
+		default:
+			return fmt.Errorf("unsupported operation %v for PatchTargetNetworkFilter", p.Operation)
+		}
+		applied = true
+	}
+	if !applied {
+		return fmt.Errorf("no filter chain/filter %q matched %+v", p.FilterName, p.Match)
+	}
+	return nil
+}
+
+func networkFilterIndex(filters []listener.Filter, name string) int {
+	for i, f := range filters {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertNetworkFilter(filters []listener.Filter, at int, f listener.Filter) []listener.Filter {
+	filters = append(filters, listener.Filter{})
+	copy(filters[at+1:], filters[at:])
+	filters[at] = f
+	return filters
+}
+
+// applyHTTPFilterPatch applies p to the HttpFilters list of the envoy.http_connection_manager
+// filter in every matching filter chain. It round-trips that filter's Config through
+// util.StructToMessage/util.MessageToStruct, since HttpConnectionManager is itself opaquely
+// serialized by marshalFilters before applyListenerPatches ever runs.
+func applyHTTPFilterPatch(p *ListenerPatch, l *xdsapi.Listener) error {
+	patch, _ := p.Value.(*http_conn.HttpFilter)
+	if p.Operation != PatchRemove && patch == nil {
+		return fmt.Errorf("http filter patch value must be a *http_conn.HttpFilter, got %T", p.Value)
+	}
+
+	applied := false
+	for i := range l.FilterChains {
+		chain := &l.FilterChains[i]
+		if !filterChainMatches(p.Match, chain) {
+			continue
+		}
+		hcmIdx := networkFilterIndex(chain.Filters, envoyHTTPConnectionManager)
+		if hcmIdx < 0 {
+			continue
+		}
+		hcmFilter := &chain.Filters[hcmIdx]
+		hcm := &http_conn.HttpConnectionManager{}
+		if err := util.StructToMessage(hcmFilter.GetConfig(), hcm); err != nil {
+			return fmt.Errorf("decoding http_connection_manager config: %v", err)
+		}
+
+		idx := httpFilterIndex(hcm.HttpFilters, p.FilterName)
+		switch p.Operation {
+		case PatchAdd:
+			hcm.HttpFilters = append(hcm.HttpFilters, cloneHTTPFilter(patch))
+		case PatchRemove:
+			if idx < 0 {
+				continue
+			}
+			hcm.HttpFilters = append(hcm.HttpFilters[:idx], hcm.HttpFilters[idx+1:]...)
+		case PatchInsertBefore:
+			if idx < 0 {
+				continue
+			}
+			hcm.HttpFilters = insertHTTPFilter(hcm.HttpFilters, idx, cloneHTTPFilter(patch))
+		case PatchInsertAfter:
+			if idx < 0 {
+				continue
+			}
+			hcm.HttpFilters = insertHTTPFilter(hcm.HttpFilters, idx+1, cloneHTTPFilter(patch))
+		case PatchMerge:
+			if idx < 0 {
+				continue
+			}
+			proto.Merge(hcm.HttpFilters[idx], patch)
+		default:
+			return fmt.Errorf("unsupported operation %v for PatchTargetHTTPFilter", p.Operation)
+		}
+
+		hcmFilter.Config = util.MessageToStruct(hcm)
+		applied = true
+	}
+	if !applied {
+		return fmt.Errorf("no filter chain/http filter %q matched %+v", p.FilterName, p.Match)
+	}
+	return nil
+}
+
+func httpFilterIndex(filters []*http_conn.HttpFilter, name string) int {
+	for i, f := range filters {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertHTTPFilter(filters []*http_conn.HttpFilter, at int, f *http_conn.HttpFilter) []*http_conn.HttpFilter {
+	filters = append(filters, nil)
+	copy(filters[at+1:], filters[at:])
+	filters[at] = f
+	return filters
+}
+
+// dropEmptyFilterChains removes any filter chain left with no filters after patching, since Envoy
+// rejects a listener containing one. DefaultFilterChain is left untouched: it's documented to
+// run only a TLS-terminating filter chain match with no filters of its own.
+func dropEmptyFilterChains(l *xdsapi.Listener) {
+	kept := l.FilterChains[:0]
+	for _, chain := range l.FilterChains {
+		if len(chain.Filters) == 0 {
+			log.Debugf("applyListenerPatches: dropping filter chain with no filters left on listener %s", l.Name)
+			continue
+		}
+		kept = append(kept, chain)
+	}
+	l.FilterChains = kept
+}
@@ -0,0 +1,269 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"reflect"
+	"testing"
+
+	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	http_conn "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/types"
+
+	"istio.io/istio/pilot/pkg/networking/util"
+)
+
+func namedFilter(name string) listener.Filter {
+	return listener.Filter{Name: name}
+}
+
+func chainWithFilters(names ...string) listener.FilterChain {
+	chain := listener.FilterChain{}
+	for _, n := range names {
+		chain.Filters = append(chain.Filters, namedFilter(n))
+	}
+	return chain
+}
+
+func filterNames(chain listener.FilterChain) []string {
+	var out []string
+	for _, f := range chain.Filters {
+		out = append(out, f.Name)
+	}
+	return out
+}
+
+// TestApplyListenerPatchesListenerTarget covers PatchTargetListener's PatchMerge (and the
+// PatchAdd no-op-merge fallback documented on applyListenerTargetPatch), and the PatchRemove
+// short-circuit that signals the whole listener should be dropped.
+func TestApplyListenerPatchesListenerTarget(t *testing.T) {
+	t.Run("merge", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo"}
+		patches := []*ListenerPatch{{
+			Context:   PatchContextSidecarOutbound,
+			Operation: PatchMerge,
+			Target:    PatchTargetListener,
+			Value:     &xdsapi.Listener{UseOriginalDst: &types.BoolValue{Value: true}},
+		}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, patches, l)
+		if got == nil || !got.UseOriginalDst.GetValue() {
+			t.Fatalf("applyListenerPatches() = %+v, want UseOriginalDst merged in", got)
+		}
+	})
+
+	t.Run("remove drops the listener", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo"}
+		patches := []*ListenerPatch{{
+			Context:   PatchContextSidecarOutbound,
+			Operation: PatchRemove,
+			Target:    PatchTargetListener,
+		}}
+		if got := applyListenerPatches(PatchContextSidecarOutbound, patches, l); got != nil {
+			t.Fatalf("applyListenerPatches() = %+v, want nil", got)
+		}
+	})
+
+	t.Run("context mismatch is not applied", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo"}
+		patches := []*ListenerPatch{{
+			Context:   PatchContextSidecarInbound,
+			Operation: PatchRemove,
+			Target:    PatchTargetListener,
+		}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, patches, l)
+		if got == nil || got.Name != "foo" {
+			t.Fatalf("applyListenerPatches() = %+v, want the listener untouched", got)
+		}
+	})
+}
+
+// TestApplyListenerPatchesFilterChainTarget covers PatchTargetFilterChain's add/insert-before/
+// insert-after/remove, and the "no match" not-applied path for an operation that needs one.
+func TestApplyListenerPatchesFilterChainTarget(t *testing.T) {
+	newChain := func(sni string) listener.FilterChain {
+		return listener.FilterChain{
+			FilterChainMatch: &listener.FilterChainMatch{SniDomains: []string{sni}},
+			Filters:          []listener.Filter{namedFilter("envoy.tcp_proxy")},
+		}
+	}
+
+	t.Run("add appends a new chain", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{chainWithFilters("existing")}}
+		patches := []*ListenerPatch{{
+			Context:   PatchContextSidecarOutbound,
+			Operation: PatchAdd,
+			Target:    PatchTargetFilterChain,
+			Value:     func() *listener.FilterChain { c := newChain("foo.com"); return &c }(),
+		}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, patches, l)
+		if len(got.FilterChains) != 2 {
+			t.Fatalf("FilterChains = %d, want 2", len(got.FilterChains))
+		}
+	})
+
+	t.Run("insert before and after", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{
+			{FilterChainMatch: &listener.FilterChainMatch{SniDomains: []string{"middle.com"}}},
+		}}
+		before := &listener.FilterChain{FilterChainMatch: &listener.FilterChainMatch{SniDomains: []string{"before.com"}}}
+		after := &listener.FilterChain{FilterChainMatch: &listener.FilterChainMatch{SniDomains: []string{"after.com"}}}
+		match := ListenerMatch{FilterChainSNI: "middle.com"}
+
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{
+			{Context: PatchContextSidecarOutbound, Operation: PatchInsertBefore, Target: PatchTargetFilterChain, Match: match, Value: before},
+			{Context: PatchContextSidecarOutbound, Operation: PatchInsertAfter, Target: PatchTargetFilterChain, Match: match, Value: after},
+		}, l)
+
+		if len(got.FilterChains) != 3 {
+			t.Fatalf("FilterChains = %d, want 3", len(got.FilterChains))
+		}
+		wantOrder := []string{"before.com", "middle.com", "after.com"}
+		for i, want := range wantOrder {
+			if got.FilterChains[i].FilterChainMatch.SniDomains[0] != want {
+				t.Errorf("FilterChains[%d] SNI = %q, want %q", i, got.FilterChains[i].FilterChainMatch.SniDomains[0], want)
+			}
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{
+			{FilterChainMatch: &listener.FilterChainMatch{SniDomains: []string{"gone.com"}}, Filters: []listener.Filter{namedFilter("f")}},
+		}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+			Context: PatchContextSidecarOutbound, Operation: PatchRemove, Target: PatchTargetFilterChain,
+			Match: ListenerMatch{FilterChainSNI: "gone.com"},
+		}}, l)
+		if len(got.FilterChains) != 0 {
+			t.Fatalf("FilterChains = %d, want 0", len(got.FilterChains))
+		}
+	})
+
+	t.Run("no match is not applied", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{chainWithFilters("f")}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+			Context: PatchContextSidecarOutbound, Operation: PatchRemove, Target: PatchTargetFilterChain,
+			Match: ListenerMatch{FilterChainSNI: "nonexistent.com"},
+		}}, l)
+		if len(got.FilterChains) != 1 {
+			t.Fatalf("FilterChains = %d, want 1 (unchanged, no match)", len(got.FilterChains))
+		}
+	})
+}
+
+// TestApplyListenerPatchesNetworkFilterTarget covers PatchTargetNetworkFilter's add/remove/
+// insert-before/insert-after/merge by FilterName, and confirms an unmatched FilterName is a
+// not-applied no-op rather than an error that drops other patches.
+func TestApplyListenerPatchesNetworkFilterTarget(t *testing.T) {
+	t.Run("add", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{chainWithFilters("envoy.tcp_proxy")}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+			Context: PatchContextSidecarOutbound, Operation: PatchAdd, Target: PatchTargetNetworkFilter,
+			Value: &listener.Filter{Name: "envoy.ext_authz"},
+		}}, l)
+		if want := []string{"envoy.tcp_proxy", "envoy.ext_authz"}; !reflect.DeepEqual(filterNames(got.FilterChains[0]), want) {
+			t.Fatalf("filters = %v, want %v", filterNames(got.FilterChains[0]), want)
+		}
+	})
+
+	t.Run("insert before and after by name", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{chainWithFilters("mid")}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{
+			{Context: PatchContextSidecarOutbound, Operation: PatchInsertBefore, Target: PatchTargetNetworkFilter,
+				FilterName: "mid", Value: &listener.Filter{Name: "before"}},
+			{Context: PatchContextSidecarOutbound, Operation: PatchInsertAfter, Target: PatchTargetNetworkFilter,
+				FilterName: "mid", Value: &listener.Filter{Name: "after"}},
+		}, l)
+		if want := []string{"before", "mid", "after"}; !reflect.DeepEqual(filterNames(got.FilterChains[0]), want) {
+			t.Fatalf("filters = %v, want %v", filterNames(got.FilterChains[0]), want)
+		}
+	})
+
+	t.Run("remove by name", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{chainWithFilters("a", "b")}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+			Context: PatchContextSidecarOutbound, Operation: PatchRemove, Target: PatchTargetNetworkFilter,
+			FilterName: "a",
+		}}, l)
+		if want := []string{"b"}; !reflect.DeepEqual(filterNames(got.FilterChains[0]), want) {
+			t.Fatalf("filters = %v, want %v", filterNames(got.FilterChains[0]), want)
+		}
+	})
+
+	t.Run("unmatched filter name is not applied", func(t *testing.T) {
+		l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{chainWithFilters("a")}}
+		got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+			Context: PatchContextSidecarOutbound, Operation: PatchRemove, Target: PatchTargetNetworkFilter,
+			FilterName: "nonexistent",
+		}}, l)
+		if want := []string{"a"}; !reflect.DeepEqual(filterNames(got.FilterChains[0]), want) {
+			t.Fatalf("filters = %v, want %v (unchanged)", filterNames(got.FilterChains[0]), want)
+		}
+	})
+}
+
+// TestApplyListenerPatchesHTTPFilterTarget covers PatchTargetHTTPFilter's add, which round-trips
+// the http_connection_manager filter's opaque Config through util.StructToMessage/MessageToStruct.
+func TestApplyListenerPatchesHTTPFilterTarget(t *testing.T) {
+	hcm := &http_conn.HttpConnectionManager{
+		HttpFilters: []*http_conn.HttpFilter{{Name: "envoy.router"}},
+	}
+	l := &xdsapi.Listener{
+		Name: "foo",
+		FilterChains: []listener.FilterChain{{
+			Filters: []listener.Filter{{
+				Name:   envoyHTTPConnectionManager,
+				Config: util.MessageToStruct(hcm),
+			}},
+		}},
+	}
+
+	got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+		Context: PatchContextSidecarOutbound, Operation: PatchInsertBefore, Target: PatchTargetHTTPFilter,
+		FilterName: "envoy.router", Value: &http_conn.HttpFilter{Name: "envoy.fault"},
+	}}, l)
+
+	gotHCM := &http_conn.HttpConnectionManager{}
+	if err := util.StructToMessage(got.FilterChains[0].Filters[0].GetConfig(), gotHCM); err != nil {
+		t.Fatalf("decoding patched http_connection_manager: %v", err)
+	}
+	if len(gotHCM.HttpFilters) != 2 || gotHCM.HttpFilters[0].Name != "envoy.fault" || gotHCM.HttpFilters[1].Name != "envoy.router" {
+		t.Fatalf("HttpFilters = %+v, want [envoy.fault envoy.router]", gotHCM.HttpFilters)
+	}
+}
+
+// TestApplyListenerPatchesDropsEmptyFilterChains covers dropEmptyFilterChains: removing a filter
+// chain's only filter must drop the chain from the listener entirely, since Envoy rejects a
+// filter chain with no filters.
+func TestApplyListenerPatchesDropsEmptyFilterChains(t *testing.T) {
+	l := &xdsapi.Listener{Name: "foo", FilterChains: []listener.FilterChain{
+		chainWithFilters("only"),
+		chainWithFilters("kept"),
+	}}
+	got := applyListenerPatches(PatchContextSidecarOutbound, []*ListenerPatch{{
+		Context: PatchContextSidecarOutbound, Operation: PatchRemove, Target: PatchTargetNetworkFilter,
+		FilterName: "only",
+		Match:      ListenerMatch{},
+	}}, l)
+
+	// Both chains match the wildcard ListenerMatch, but only the first chain has "only" to
+	// remove; that chain must be dropped once empty, while the second chain survives untouched.
+	if len(got.FilterChains) != 1 {
+		t.Fatalf("FilterChains = %d, want 1 (the now-empty chain dropped)", len(got.FilterChains))
+	}
+	if filterNames(got.FilterChains[0])[0] != "kept" {
+		t.Fatalf("remaining chain = %v, want the one still holding a filter", filterNames(got.FilterChains[0]))
+	}
+}
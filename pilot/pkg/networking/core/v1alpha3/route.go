@@ -0,0 +1,90 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	"github.com/gogo/protobuf/types"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// buildRouteActionTimeout sets action's per-route timeout from a VirtualService HTTP route's
+// Timeout and MaxStreamDuration fields, the way applyLoadBalancer/applyConsistentHashLoadBalancer
+// set a cluster's load-balancing fields from a DestinationRule in cluster.go.
+// ConfigGeneratorImpl.BuildSidecarOutboundHTTPRouteConfig (defined outside this checkout, see the
+// doc comment on buildSidecarOutboundListeners below) is expected to call this once per generated
+// RouteAction. Both inputs are nil-able and handled independently of each other so an operator
+// can set just one:
+//   - timeout nil: Timeout was unset on the VirtualService route; Envoy falls back to its own
+//     15s default. Nothing is written.
+//   - timeout non-nil, including the zero duration: emitted as-is, so {seconds: 0} explicitly
+//     disables Envoy's default timeout instead of being mistaken for "unset".
+//   - maxStreamDuration follows the same nil/zero handling, for the gRPC-header-driven
+//     grpc_timeout_header_max extension requested alongside Timeout.
+func buildRouteActionTimeout(action *route.RouteAction, timeout, maxStreamDuration *types.Duration) {
+	if timeout == nil && maxStreamDuration == nil {
+		return
+	}
+	action.MaxStreamDuration = &route.RouteAction_MaxStreamDuration{
+		MaxStreamDuration:    timeout,
+		GrpcTimeoutHeaderMax: maxStreamDuration,
+	}
+}
+
+// buildRouteActionHashPolicy translates a DestinationRule's ConsistentHash selector into the
+// route.RouteAction.HashPolicy entries Envoy needs to actually hash requests onto the RING_HASH/
+// MAGLEV cluster applyConsistentHashLoadBalancer (cluster.go) configures: building the cluster
+// without this is necessary but not sufficient for session affinity, since nothing tells Envoy
+// which part of the request to hash on. ConfigGeneratorImpl.BuildSidecarOutboundHTTPRouteConfig
+// is expected to call this for every route whose cluster has a ConsistentHash load balancer
+// configured, using the same consistentHash value applyConsistentHashLoadBalancer was called
+// with for that cluster. Returns nil if consistentHash selects none of header/cookie/source IP.
+func buildRouteActionHashPolicy(consistentHash *networking.LoadBalancerSettings_ConsistentHashLB) []*route.RouteAction_HashPolicy {
+	if consistentHash == nil {
+		return nil
+	}
+
+	switch {
+	case consistentHash.GetHttpHeaderName() != "":
+		return []*route.RouteAction_HashPolicy{{
+			PolicySpecifier: &route.RouteAction_HashPolicy_Header_{
+				Header: &route.RouteAction_HashPolicy_Header{
+					HeaderName: consistentHash.GetHttpHeaderName(),
+				},
+			},
+		}}
+	case consistentHash.GetHttpCookie() != nil:
+		cookie := consistentHash.GetHttpCookie()
+		return []*route.RouteAction_HashPolicy{{
+			PolicySpecifier: &route.RouteAction_HashPolicy_Cookie_{
+				Cookie: &route.RouteAction_HashPolicy_Cookie{
+					Name: cookie.GetName(),
+					Path: cookie.GetPath(),
+					Ttl:  cookie.GetTtl(),
+				},
+			},
+		}}
+	case consistentHash.GetUseSourceIp():
+		return []*route.RouteAction_HashPolicy{{
+			PolicySpecifier: &route.RouteAction_HashPolicy_ConnectionProperties_{
+				ConnectionProperties: &route.RouteAction_HashPolicy_ConnectionProperties{
+					SourceIp: true,
+				},
+			},
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha3
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	"github.com/gogo/protobuf/types"
+
+	networking "istio.io/api/networking/v1alpha3"
+)
+
+// TestBuildRouteActionTimeout covers the nil/zero/set matrix requested for a VirtualService's
+// Timeout: a VirtualService with timeout: 5s must produce a RouteAction whose
+// MaxStreamDuration.MaxStreamDuration is 5s, an explicit zero must be emitted rather than
+// dropped, and leaving Timeout unset must leave MaxStreamDuration nil entirely.
+func TestBuildRouteActionTimeout(t *testing.T) {
+	fiveSeconds := &types.Duration{Seconds: 5}
+	zero := &types.Duration{}
+
+	cases := []struct {
+		name              string
+		timeout           *types.Duration
+		maxStreamDuration *types.Duration
+		want              *route.RouteAction_MaxStreamDuration
+	}{
+		{name: "unset", want: nil},
+		{
+			name:    "5s timeout",
+			timeout: fiveSeconds,
+			want:    &route.RouteAction_MaxStreamDuration{MaxStreamDuration: fiveSeconds},
+		},
+		{
+			name:    "explicit zero disables the default",
+			timeout: zero,
+			want:    &route.RouteAction_MaxStreamDuration{MaxStreamDuration: zero},
+		},
+		{
+			name:              "grpc_timeout_header_max only",
+			maxStreamDuration: fiveSeconds,
+			want:              &route.RouteAction_MaxStreamDuration{GrpcTimeoutHeaderMax: fiveSeconds},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			action := &route.RouteAction{}
+			buildRouteActionTimeout(action, c.timeout, c.maxStreamDuration)
+
+			if c.want == nil {
+				if action.MaxStreamDuration != nil {
+					t.Errorf("MaxStreamDuration = %+v, want nil", action.MaxStreamDuration)
+				}
+				return
+			}
+			if action.MaxStreamDuration == nil {
+				t.Fatalf("MaxStreamDuration = nil, want %+v", c.want)
+			}
+			if action.MaxStreamDuration.MaxStreamDuration != c.want.MaxStreamDuration {
+				t.Errorf("MaxStreamDuration.MaxStreamDuration = %v, want %v",
+					action.MaxStreamDuration.MaxStreamDuration, c.want.MaxStreamDuration)
+			}
+			if action.MaxStreamDuration.GrpcTimeoutHeaderMax != c.want.GrpcTimeoutHeaderMax {
+				t.Errorf("MaxStreamDuration.GrpcTimeoutHeaderMax = %v, want %v",
+					action.MaxStreamDuration.GrpcTimeoutHeaderMax, c.want.GrpcTimeoutHeaderMax)
+			}
+		})
+	}
+}
+
+// TestBuildRouteActionHashPolicy covers each ConsistentHash selector applyConsistentHashLoadBalancer
+// also accepts (header, cookie, source IP), so a DestinationRule's hash key selection ends up on
+// the route the same way it ends up on the cluster.
+func TestBuildRouteActionHashPolicy(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		got := buildRouteActionHashPolicy(&networking.LoadBalancerSettings_ConsistentHashLB{
+			HashKey: &networking.LoadBalancerSettings_ConsistentHashLB_HttpHeaderName{HttpHeaderName: "x-user"},
+		})
+		if len(got) != 1 {
+			t.Fatalf("got %d hash policies, want 1", len(got))
+		}
+		header := got[0].GetHeader()
+		if header == nil || header.HeaderName != "x-user" {
+			t.Errorf("hash policy = %+v, want header x-user", got[0])
+		}
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		got := buildRouteActionHashPolicy(&networking.LoadBalancerSettings_ConsistentHashLB{
+			HashKey: &networking.LoadBalancerSettings_ConsistentHashLB_HttpCookie{
+				HttpCookie: &networking.LoadBalancerSettings_ConsistentHashLB_HTTPCookie{
+					Name: "session", Ttl: &types.Duration{Seconds: 120},
+				},
+			},
+		})
+		if len(got) != 1 {
+			t.Fatalf("got %d hash policies, want 1", len(got))
+		}
+		cookie := got[0].GetCookie()
+		if cookie == nil || cookie.Name != "session" || cookie.Ttl.Seconds != 120 {
+			t.Errorf("hash policy = %+v, want cookie session/120s", got[0])
+		}
+	})
+
+	t.Run("source ip", func(t *testing.T) {
+		got := buildRouteActionHashPolicy(&networking.LoadBalancerSettings_ConsistentHashLB{
+			HashKey: &networking.LoadBalancerSettings_ConsistentHashLB_UseSourceIp{UseSourceIp: true},
+		})
+		if len(got) != 1 {
+			t.Fatalf("got %d hash policies, want 1", len(got))
+		}
+		if cp := got[0].GetConnectionProperties(); cp == nil || !cp.SourceIp {
+			t.Errorf("hash policy = %+v, want source IP", got[0])
+		}
+	})
+
+	t.Run("nil consistent hash", func(t *testing.T) {
+		if got := buildRouteActionHashPolicy(nil); got != nil {
+			t.Errorf("hash policy = %+v, want nil", got)
+		}
+	})
+}
@@ -0,0 +1,390 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Envoy v1 (JSON) data-plane API types shared by the builders in this package:
+// clusters, routes, virtual hosts and their supporting structures. Fields are
+// tagged for encoding/json so builder output serializes directly into the
+// CDS/RDS JSON Envoy v1 expects.
+
+package v1
+
+import (
+	"github.com/golang/protobuf/ptypes/duration"
+
+	routing "istio.io/api/routing/v1alpha1"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+const (
+	// ClusterTypeStatic is a cluster whose hosts are a fixed, operator-provided list.
+	ClusterTypeStatic = "static"
+
+	// ClusterTypeStrictDNS is a cluster whose hosts are resolved via strict DNS.
+	ClusterTypeStrictDNS = "strict_dns"
+
+	// ClusterTypeSDS is a cluster whose hosts are resolved via Istio's discovery service.
+	ClusterTypeSDS = "sds"
+
+	// ClusterTypeOriginalDST forwards to the connection's original destination address.
+	ClusterTypeOriginalDST = "original_dst"
+
+	// DefaultLbType is the load balancing policy used unless a cluster overrides it.
+	DefaultLbType = "round_robin"
+
+	// LbTypeOriginalDST is the (degenerate) load balancing policy for original-dst clusters.
+	LbTypeOriginalDST = "original_dst_lb"
+
+	// ZipkinTraceDriverType is the Envoy HTTP tracer driver name for Zipkin (and, in
+	// 128-bit-trace-ID mode, Jaeger).
+	ZipkinTraceDriverType = "zipkin"
+
+	// ZipkinCollectorCluster is the default Envoy cluster name the Zipkin tracer reports to.
+	ZipkinCollectorCluster = "zipkin"
+
+	// ZipkinCollectorEndpoint is the default HTTP path the Zipkin collector expects spans on.
+	ZipkinCollectorEndpoint = "/api/v1/spans"
+)
+
+// Host is a single static host entry in a Cluster.
+type Host struct {
+	URL string `json:"url"`
+
+	// Locality is the "region/zone/subzone" this host's endpoint was discovered in, if
+	// known. Envoy's v1 JSON API has no per-host locality field of its own; LocalityLbSetting
+	// instead keys its Distribute/Failover weights off this value when applyLocalityLbSetting
+	// decides which zone-aware/locality-weighted settings apply to the cluster as a whole.
+	Locality string `json:"-"`
+}
+
+// Cluster is an Envoy v1 CDS cluster: either a fixed/DNS-resolved/SDS set of hosts, an
+// aggregate of other clusters, or an original-destination forwarder.
+type Cluster struct {
+	Name             string `json:"name"`
+	ServiceName      string `json:"service_name,omitempty"`
+	Type             string `json:"type"`
+	LbType           string `json:"lb_type"`
+	ConnectTimeoutMs int64  `json:"connect_timeout_ms,omitempty"`
+	Hosts            []Host `json:"hosts,omitempty"`
+
+	Features string `json:"features,omitempty"`
+
+	// AggregateClusterConfig is set for ClusterTypeAggregate clusters produced by
+	// BuildAggregateCluster.
+	AggregateClusterConfig *AggregateClusterConfig `json:"-"`
+
+	// RetryBudget is surfaced from the route(s) targeting this cluster, capping retry
+	// amplification independent of any single route's own retry policy.
+	RetryBudget *RetryBudget `json:"-"`
+
+	// CommonLbConfig and LocalityLbSetting hold the locality-weighted/zone-aware settings
+	// applied by applyLocalityLbSetting.
+	CommonLbConfig    *CommonLbConfig    `json:"lb_subset_config,omitempty"`
+	LocalityLbSetting *LocalityLbSetting `json:"-"`
+
+	// OutlierDetection holds the passive health check settings applied by
+	// applyOutlierDetection.
+	OutlierDetection *OutlierDetection `json:"outlier_detection,omitempty"`
+
+	// outbound is true for clusters fronting in-mesh outbound traffic (as opposed to
+	// external/original-dst destinations). Not serialized; used only to drive builder logic.
+	outbound bool
+
+	// Hostname and Port identify the service/port this cluster was built for. Not
+	// serialized; used only to drive builder logic (e.g. locality lookups).
+	Hostname string
+	Port     *model.Port
+
+	// labels are the destination subset labels this cluster was built for. Not
+	// serialized; used only to drive builder logic.
+	labels model.Labels
+}
+
+// MakeHTTP2 marks the cluster as speaking HTTP/2 to its upstream hosts.
+func (c *Cluster) MakeHTTP2() {
+	c.Features = "http2"
+}
+
+// Decorator sets the operation name recorded on spans for requests matching a route.
+type Decorator struct {
+	Operation string `json:"operation"`
+}
+
+// AppendedHeader is a single static header to add to requests matching a route.
+type AppendedHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// CORSPolicy configures cross-origin resource sharing for a route.
+type CORSPolicy struct {
+	Enabled          bool   `json:"enabled"`
+	AllowOrigin      string `json:"allow_origin,omitempty"`
+	AllowCredentials bool   `json:"allow_credentials,omitempty"`
+	AllowHeaders     string `json:"allow_headers,omitempty"`
+	AllowMethods     string `json:"allow_methods,omitempty"`
+	ExposeHeaders    string `json:"expose_headers,omitempty"`
+	MaxAge           int    `json:"max_age,omitempty"`
+}
+
+// WeightedClusterEntry is a single weighted destination within a WeightedCluster.
+type WeightedClusterEntry struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// WeightedCluster splits traffic across multiple clusters by relative weight.
+type WeightedCluster struct {
+	Clusters []*WeightedClusterEntry `json:"clusters"`
+}
+
+// ShadowCluster mirrors (a fraction of) a route's traffic to another cluster, without
+// affecting the response seen by the caller.
+type ShadowCluster struct {
+	Cluster string `json:"cluster"`
+
+	// RuntimeFraction limits mirroring to a fraction of requests; nil mirrors all of them.
+	RuntimeFraction *RuntimeFraction `json:"runtime_fraction,omitempty"`
+
+	// TraceSampled controls whether mirrored requests are eligible for trace sampling.
+	TraceSampled bool `json:"trace_sampled,omitempty"`
+}
+
+// RetryPolicy configures Envoy's request retry behavior for a route.
+type RetryPolicy struct {
+	Policy          string `json:"retry_on"`
+	NumRetries      int    `json:"num_retries,omitempty"`
+	PerTryTimeoutMS int64  `json:"per_try_timeout_ms,omitempty"`
+
+	// RetriableStatusCodes, PreviousHosts and OmitCanaryHosts extend the "retry_on" policy
+	// above with the v1alpha1 SimpleRetryPolicy knobs buildRetryPolicy translates.
+	RetriableStatusCodes []uint32 `json:"retriable_status_codes,omitempty"`
+	PreviousHosts        bool     `json:"retry_on_previous_hosts,omitempty"`
+	OmitCanaryHosts      bool     `json:"retry_on_omit_canary_hosts,omitempty"`
+
+	BaseIntervalMS int64 `json:"base_interval_ms,omitempty"`
+	MaxIntervalMS  int64 `json:"max_interval_ms,omitempty"`
+
+	// Budget caps the fraction of in-flight requests that may themselves be retries, and is
+	// also surfaced onto every cluster the route targets (see Cluster.RetryBudget).
+	Budget *RetryBudget `json:"-"`
+}
+
+// HTTPFilter is a per-route HTTP filter config, e.g. the fault injection filter built by
+// buildHTTPFaultFilter.
+type HTTPFilter struct {
+	Type   string      `json:"type"`
+	Name   string      `json:"name"`
+	Config interface{} `json:"config"`
+}
+
+// HTTPRoute is a single Envoy v1 RDS route: a match (prefix/path/regex, headers, query
+// params) plus the cluster(s) and transformations to apply when it matches.
+type HTTPRoute struct {
+	Prefix string `json:"prefix,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// RegexPath matches the request path by regex instead of Prefix/Path; set by
+	// applyExtendedMatch when a MatchCondition's uri header carries a regex.
+	RegexPath string `json:"regex,omitempty"`
+
+	Cluster          string           `json:"cluster,omitempty"`
+	WeightedClusters *WeightedCluster `json:"weighted_clusters,omitempty"`
+
+	// Clusters lists every cluster this route references (including weighted/aggregate
+	// members and the mirror destination), so builders can visit them without re-deriving
+	// them from Cluster/WeightedClusters. Not serialized; Envoy only reads Cluster and
+	// WeightedClusters.
+	Clusters []*Cluster `json:"-"`
+
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// HeaderMatches and QueryParameterMatches hold the richer predicates
+	// applyExtendedMatch adds on top of Headers' plain exact/prefix matches.
+	HeaderMatches         []HeaderMatch         `json:"header_matches,omitempty"`
+	QueryParameterMatches []QueryParameterMatch `json:"query_parameter_matches,omitempty"`
+
+	HeadersToAdd []AppendedHeader `json:"headers_to_add,omitempty"`
+
+	HostRedirect  string `json:"host_redirect,omitempty"`
+	PathRedirect  string `json:"path_redirect,omitempty"`
+	HostRewrite   string `json:"host_rewrite,omitempty"`
+	PrefixRewrite string `json:"prefix_rewrite,omitempty"`
+
+	WebsocketUpgrade bool  `json:"websocket_upgrade,omitempty"`
+	TimeoutMS        int64 `json:"timeout_ms,omitempty"`
+
+	RetryPolicy *RetryPolicy `json:"retry_policy,omitempty"`
+
+	// ShadowClusters lists every mirror destination for this route; a route may mirror
+	// more than once (e.g. across successive Mirror rules), so this is a list rather than
+	// a single ShadowCluster.
+	ShadowClusters []*ShadowCluster `json:"shadow_clusters,omitempty"`
+
+	CORSPolicy *CORSPolicy `json:"cors_policy,omitempty"`
+
+	Decorator *Decorator `json:"decorator,omitempty"`
+
+	// faults lists the fault injection filters built for this route's cluster(s). Not
+	// serialized directly; the listener builder reads it when assembling per-route filter
+	// config.
+	faults []*HTTPFilter
+}
+
+// VirtualHost groups routes under a shared set of domains.
+type VirtualHost struct {
+	Name    string       `json:"name"`
+	Domains []string     `json:"domains"`
+	Routes  []*HTTPRoute `json:"routes"`
+}
+
+// TCPRoute forwards raw TCP connections from a set of destination IPs to a cluster.
+type TCPRoute struct {
+	Cluster           string   `json:"cluster"`
+	DestinationIPList []string `json:"destination_ip_list,omitempty"`
+
+	// clusterRef is the Cluster this route forwards to. Not serialized; kept so callers
+	// building the surrounding listener config don't need to re-resolve it by name.
+	clusterRef *Cluster
+}
+
+// SSLContext configures a listener's (downstream) TLS termination.
+type SSLContext struct {
+	CertChainFile            string `json:"cert_chain_file"`
+	PrivateKeyFile           string `json:"private_key_file"`
+	CaCertFile               string `json:"ca_cert_file,omitempty"`
+	RequireClientCertificate bool   `json:"require_client_certificate"`
+}
+
+// SSLContextWithSAN configures a cluster's (upstream) TLS origination, additionally
+// verifying the peer's SAN against an allowed list of service accounts.
+type SSLContextWithSAN struct {
+	CertChainFile        string   `json:"cert_chain_file"`
+	PrivateKeyFile       string   `json:"private_key_file"`
+	CaCertFile           string   `json:"ca_cert_file,omitempty"`
+	VerifySubjectAltName []string `json:"verify_subject_alt_name,omitempty"`
+}
+
+// HTTPTraceDriverConfig configures the collector a tracing driver reports spans to.
+type HTTPTraceDriverConfig struct {
+	CollectorCluster  string `json:"collector_cluster,omitempty"`
+	CollectorEndpoint string `json:"collector_endpoint,omitempty"`
+	TraceID128Bit     bool   `json:"trace_id_128bit,omitempty"`
+}
+
+// HTTPTraceDriver selects and configures a tracing backend.
+type HTTPTraceDriver struct {
+	HTTPTraceDriverType   string `json:"type"`
+	HTTPTraceDriverConfig `json:"config"`
+}
+
+// HTTPTracer wraps the driver used to report spans.
+type HTTPTracer struct {
+	HTTPTraceDriver `json:"http"`
+}
+
+// Tracing is the top-level tracing configuration attached to an HTTP connection manager.
+type Tracing struct {
+	HTTPTracer `json:"http"`
+}
+
+// TruncateClusterName truncates name to Envoy's 60-byte cluster name limit, in the same
+// way for every cluster so truncated names still collide (rather than silently diverge)
+// when two distinct names share a 60-byte prefix.
+func TruncateClusterName(name string) string {
+	const maxClusterNameLength = 60
+	if len(name) > maxClusterNameLength {
+		return name[:maxClusterNameLength]
+	}
+	return name
+}
+
+// protoDurationToMS converts a protobuf Duration to milliseconds, treating nil as 0.
+func protoDurationToMS(d *duration.Duration) int64 {
+	if d == nil {
+		return 0
+	}
+	return int64(d.Seconds)*1000 + int64(d.Nanos)/1e6
+}
+
+// buildHTTPRouteMatch builds the base HTTPRoute (prefix/exact path and simple exact/prefix
+// header matches) from a MatchCondition. Richer matching (regex path, header
+// presence/suffix/regex, query parameters) is layered on afterward by applyExtendedMatch.
+func buildHTTPRouteMatch(match *routing.MatchCondition) *HTTPRoute {
+	route := &HTTPRoute{Prefix: "/"}
+	if match == nil {
+		return route
+	}
+
+	if uri := match.GetRequest().GetHeaders()[model.HeaderURI]; uri != nil {
+		switch {
+		case uri.GetExact() != "":
+			route.Prefix = ""
+			route.Path = uri.GetExact()
+		case uri.GetPrefix() != "":
+			route.Prefix = uri.GetPrefix()
+		}
+	}
+
+	for name, value := range match.GetRequest().GetHeaders() {
+		if name == model.HeaderURI {
+			continue
+		}
+		switch {
+		case value.GetExact() != "":
+			if route.Headers == nil {
+				route.Headers = make(map[string]string)
+			}
+			route.Headers[name] = value.GetExact()
+		case value.GetPrefix() != "":
+			if route.Headers == nil {
+				route.Headers = make(map[string]string)
+			}
+			route.Headers[name] = value.GetPrefix()
+		}
+	}
+
+	return route
+}
+
+// buildHTTPFaultFilter builds the fault injection HTTPFilter for a single cluster, or
+// returns nil if fault has neither a delay nor an abort configured.
+func buildHTTPFaultFilter(cluster string, fault *routing.HTTPFaultInjection, headers map[string]string) *HTTPFilter {
+	if fault == nil || (fault.Delay == nil && fault.Abort == nil) {
+		return nil
+	}
+
+	config := map[string]interface{}{"upstream_cluster": cluster}
+	if len(headers) > 0 {
+		config["headers"] = headers
+	}
+	if fault.Delay != nil {
+		config["delay"] = map[string]interface{}{
+			"type":                "fixed",
+			"fixed_delay_percent": fault.Delay.Percent,
+			"fixed_duration_ms":   protoDurationToMS(fault.Delay.GetFixedDelay()),
+		}
+	}
+	if fault.Abort != nil {
+		config["abort"] = map[string]interface{}{
+			"abort_percent": fault.Abort.Percent,
+			"http_status":   fault.Abort.GetHttpStatus(),
+		}
+	}
+
+	return &HTTPFilter{
+		Type:   "decoder",
+		Name:   "fault",
+		Config: config,
+	}
+}
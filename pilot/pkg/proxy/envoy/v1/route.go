@@ -26,8 +26,10 @@ import (
 	"github.com/golang/protobuf/ptypes/duration"
 
 	authn "istio.io/api/authentication/v1alpha1"
+	meshconfig "istio.io/api/mesh/v1alpha1"
 	routing "istio.io/api/routing/v1alpha1"
 	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
 )
 
 const (
@@ -36,8 +38,148 @@ const (
 
 	// OutboundClusterPrefix is the prefix for service clusters external to the proxy instance
 	OutboundClusterPrefix = "out."
+
+	// ClusterTypeAggregate designates a cluster whose membership is a priority-ordered
+	// list of other clusters rather than a set of hosts.
+	ClusterTypeAggregate = "aggregate"
+
+	// DefaultAggregateHealthPercentThreshold is the panic threshold (percentage of healthy
+	// hosts) below which Envoy spills over to the next priority tier in an aggregate cluster.
+	DefaultAggregateHealthPercentThreshold = 40
+
+	// DefaultMaxEjectionPercent caps the fraction of a cluster's hosts that passive health
+	// checking may eject at once, matching Envoy's own default.
+	DefaultMaxEjectionPercent = 10
+
+	// DefaultZoneAwareMinClusterSize is the minimum number of hosts a cluster must have
+	// before Envoy will attempt zone-aware routing, matching Envoy's own default.
+	DefaultZoneAwareMinClusterSize = 6
+
+	// RuntimeFractionDenominatorTenThousand matches Envoy's FractionalPercent.TEN_THOUSAND,
+	// giving runtime mirror fractions one hundredth of a percentage point of precision.
+	RuntimeFractionDenominatorTenThousand = "TEN_THOUSAND"
+
+	// percentToTenThousandthsScale converts a mirror.Percent (0.0-100.0) into the numerator
+	// of a RuntimeFractionDenominatorTenThousand fraction.
+	percentToTenThousandthsScale = 100
 )
 
+// RuntimeFraction is the {numerator, denominator} pair Envoy uses to express a
+// percentage as a runtime-overridable fraction, used here to mirror only a fraction of
+// traffic to a ShadowCluster.
+type RuntimeFraction struct {
+	Numerator   uint32 `json:"numerator"`
+	Denominator string `json:"denominator"`
+}
+
+// LocalityWeight assigns a relative weight to hosts in a given locality, for use in
+// LocalityLbSetting.Distribute.
+type LocalityWeight struct {
+	// From is the caller's locality, in "region/zone/subzone" form (subzone optional).
+	From string `json:"from"`
+
+	// To maps destination localities to their relative weight.
+	To map[string]uint32 `json:"to"`
+}
+
+// LocalityFailover declares that, when no hosts are available in From, traffic should
+// fail over to the given locality instead of being spread across all remaining ones.
+type LocalityFailover struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// LocalityLbSetting configures locality-weighted load balancing and zone-aware failover
+// for a cluster, derived from a TrafficPolicy.
+type LocalityLbSetting struct {
+	// Distribute lists the explicit locality weight distribution to use; if empty, Envoy's
+	// default zone-aware load balancing applies.
+	Distribute []LocalityWeight `json:"distribute,omitempty"`
+
+	// Failover lists locality failover priorities to fall back through when a locality has
+	// no healthy hosts and Distribute doesn't apply.
+	Failover []LocalityFailover `json:"failover,omitempty"`
+
+	// MinClusterSize is the minimum number of hosts required before zone-aware routing
+	// kicks in. Defaults to DefaultZoneAwareMinClusterSize.
+	MinClusterSize uint64 `json:"min_cluster_size,omitempty"`
+
+	// PanicThreshold is the percentage of healthy hosts below which Envoy abandons
+	// locality-aware routing and spreads traffic across all localities.
+	PanicThreshold float64 `json:"panic_threshold,omitempty"`
+}
+
+// ZoneAwareLbConfig is the Envoy CommonLbConfig.zone_aware_lb_config equivalent.
+type ZoneAwareLbConfig struct {
+	MinClusterSize uint64  `json:"min_cluster_size,omitempty"`
+	PanicThreshold float64 `json:"fail_traffic_on_panic_percentage,omitempty"`
+}
+
+// CommonLbConfig holds load balancer settings shared across LB policies.
+type CommonLbConfig struct {
+	LocalityWeightedLbConfig bool               `json:"locality_weighted_lb_config,omitempty"`
+	ZoneAwareLbConfig        *ZoneAwareLbConfig `json:"zone_aware_lb_config,omitempty"`
+}
+
+// OutlierDetection configures Envoy's passive health checking (outlier detection) for a
+// cluster: consecutive error ejection, interval-based success-rate analysis, or both.
+type OutlierDetection struct {
+	// ConsecutiveErrors is the number of consecutive 5xx (or connect failure) responses
+	// before a host is ejected.
+	ConsecutiveErrors int `json:"consecutive_5xx,omitempty"`
+
+	// ConsecutiveGatewayFailure is the number of consecutive gateway failures (502/503/504)
+	// before a host is ejected.
+	ConsecutiveGatewayFailure int `json:"consecutive_gateway_failure,omitempty"`
+
+	// IntervalMS is the time between ejection analysis sweeps.
+	IntervalMS int64 `json:"interval_ms,omitempty"`
+
+	// BaseEjectionTimeMS is the base time a host remains ejected; actual ejection time is
+	// this value multiplied by the number of times the host has been ejected.
+	BaseEjectionTimeMS int64 `json:"base_ejection_time_ms,omitempty"`
+
+	// MaxEjectionPercent caps the percentage of hosts that may be ejected at any time.
+	MaxEjectionPercent int `json:"max_ejection_percent,omitempty"`
+
+	// EnforcingSuccessRate is the percentage chance that a host detected as an outlier by
+	// success rate will actually be ejected (0-100).
+	EnforcingSuccessRate int `json:"enforcing_success_rate,omitempty"`
+
+	// SuccessRateMinimumHosts is the minimum number of hosts with enough request volume to
+	// perform success rate based outlier ejection.
+	SuccessRateMinimumHosts int `json:"success_rate_minimum_hosts,omitempty"`
+
+	// SuccessRateRequestVolume is the minimum number of total requests a host must have in
+	// the aggregation interval to be included in success rate based ejection.
+	SuccessRateRequestVolume int `json:"success_rate_request_volume,omitempty"`
+
+	// SuccessRateStdevFactor tunes how many standard deviations below the mean a host's
+	// success rate must fall before it is ejected (scaled by 1000, per Envoy's config).
+	SuccessRateStdevFactor int `json:"success_rate_stdev_factor,omitempty"`
+}
+
+// AggregateClusterMember is a single priority tier of an aggregate cluster, referencing
+// another cluster by name rather than embedding its hosts.
+type AggregateClusterMember struct {
+	// Name of the member cluster.
+	Name string `json:"name"`
+
+	// Priority of this member; lower values are preferred, higher values are used for
+	// failover once the active priority's health percentage drops below the threshold.
+	Priority int `json:"priority"`
+}
+
+// AggregateClusterConfig carries the membership and spillover configuration used by
+// BuildAggregateCluster. It intentionally leaves the per-member health percentage
+// threshold to the cluster-wide DefaultAggregateHealthPercentThreshold unless overridden.
+type AggregateClusterConfig struct {
+	Members []AggregateClusterMember `json:"members"`
+
+	// HealthPercentThreshold overrides DefaultAggregateHealthPercentThreshold when non-zero.
+	HealthPercentThreshold int `json:"health_percent_threshold,omitempty"`
+}
+
 // buildListenerSSLContext returns an SSLContext struct.
 func buildListenerSSLContext(certsDir string, mtlsParams *authn.MutualTls) *SSLContext {
 	return &SSLContext{
@@ -84,6 +226,10 @@ func BuildInboundRoute(config model.Config, rule *routing.RouteRule, cluster *Cl
 		route.Path = ""
 	}
 
+	// applyExtendedMatch runs after the rewrite above so regex/header/query matchers are
+	// still attached to the route even when the prefix has been overwritten.
+	applyExtendedMatch(route, rule.Match)
+
 	if !rule.WebsocketUpgrade {
 		route.Decorator = buildDecorator(config)
 	}
@@ -106,8 +252,12 @@ func BuildInboundCluster(port int, protocol model.Protocol, timeout *duration.Du
 	return cluster
 }
 
-// BuildOutboundCluster builds an outbound cluster.
-func BuildOutboundCluster(hostname model.Hostname, port *model.Port, labels model.Labels, isExternal bool) *Cluster {
+// BuildOutboundCluster builds an outbound cluster. If policy is non-nil, its
+// OutlierDetection settings (if any) are applied to the cluster so Envoy can passively
+// eject unhealthy hosts. If locality is non-nil, the cluster is configured for
+// locality-weighted load balancing and zone-aware failover.
+func BuildOutboundCluster(hostname model.Hostname, port *model.Port, labels model.Labels, isExternal bool,
+	policy *routing.DestinationPolicy, locality *LocalityLbSetting) *Cluster {
 	svc := model.Service{Hostname: hostname}
 	key := svc.Key(port, labels)
 	name := TruncateClusterName(OutboundClusterPrefix + key)
@@ -137,13 +287,70 @@ func BuildOutboundCluster(hostname model.Hostname, port *model.Port, labels mode
 	if port.Protocol == model.ProtocolGRPC || port.Protocol == model.ProtocolHTTP2 {
 		cluster.MakeHTTP2()
 	}
+
+	applyOutlierDetection(cluster, policy)
+	applyLocalityLbSetting(cluster, locality)
 	return cluster
 }
 
+// applyLocalityLbSetting attaches the CommonLbConfig that enables locality-weighted load
+// balancing plus zone-aware failover for the cluster. Per-endpoint locality itself isn't
+// carried on this builder's static Hosts list — SDS clusters (the common in-mesh case)
+// leave Hosts empty and get their endpoints, locality included, from the discovery
+// service at request time; Host.Locality is only meaningful for the StrictDNS/original-dst
+// paths that populate Hosts directly, and is left unset until a caller threads per-instance
+// locality through BuildOutboundCluster.
+func applyLocalityLbSetting(cluster *Cluster, locality *LocalityLbSetting) {
+	if locality == nil {
+		return
+	}
+
+	minClusterSize := locality.MinClusterSize
+	if minClusterSize == 0 {
+		minClusterSize = DefaultZoneAwareMinClusterSize
+	}
+	panicThreshold := locality.PanicThreshold
+	if panicThreshold == 0 {
+		panicThreshold = DefaultAggregateHealthPercentThreshold
+	}
+
+	cluster.CommonLbConfig = &CommonLbConfig{
+		LocalityWeightedLbConfig: true,
+		ZoneAwareLbConfig: &ZoneAwareLbConfig{
+			MinClusterSize: minClusterSize,
+			PanicThreshold: panicThreshold,
+		},
+	}
+	cluster.LocalityLbSetting = locality
+}
+
+// applyOutlierDetection copies the outlier detection (passive health check) settings
+// from a DestinationPolicy's CircuitBreaker onto the cluster, if present.
+func applyOutlierDetection(cluster *Cluster, policy *routing.DestinationPolicy) {
+	if policy == nil || policy.CircuitBreaker == nil || policy.CircuitBreaker.GetSimpleCb() == nil {
+		return
+	}
+	cb := policy.CircuitBreaker.GetSimpleCb()
+	if cb.HttpConsecutiveErrors == 0 && cb.HttpDetectionInterval == nil {
+		return
+	}
+	cluster.OutlierDetection = &OutlierDetection{
+		ConsecutiveErrors:  int(cb.HttpConsecutiveErrors),
+		IntervalMS:         protoDurationToMS(cb.HttpDetectionInterval),
+		BaseEjectionTimeMS: protoDurationToMS(cb.BaseEjectionInterval),
+		MaxEjectionPercent: int(cb.HttpMaxEjectionPercent),
+	}
+	if cb.HttpMaxEjectionPercent == 0 {
+		cluster.OutlierDetection.MaxEjectionPercent = DefaultMaxEjectionPercent
+	}
+}
+
 // BuildHTTPRoute translates a route rule to an Envoy route
-func BuildHTTPRoute(config model.Config, service *model.Service, port *model.Port, envoyv2 bool) *HTTPRoute {
+func BuildHTTPRoute(config model.Config, service *model.Service, port *model.Port, envoyv2 bool,
+	policy *routing.DestinationPolicy, locality *LocalityLbSetting) *HTTPRoute {
 	rule := config.Spec.(*routing.RouteRule)
 	route := buildHTTPRouteMatch(rule.Match)
+	applyExtendedMatch(route, rule.Match)
 
 	// setup timeouts for the route
 	if rule.HttpReqTimeout != nil &&
@@ -153,44 +360,59 @@ func BuildHTTPRoute(config model.Config, service *model.Service, port *model.Por
 	}
 
 	// setup retries
-	if rule.HttpReqRetries != nil &&
-		rule.HttpReqRetries.GetSimpleRetry() != nil &&
-		rule.HttpReqRetries.GetSimpleRetry().Attempts > 0 {
-		route.RetryPolicy = &RetryPolicy{
-			NumRetries: int(rule.HttpReqRetries.GetSimpleRetry().Attempts),
-			// These are the safest retry policies as per envoy docs
-			Policy: "5xx,connect-failure,refused-stream",
-		}
-		if protoDurationToMS(rule.HttpReqRetries.GetSimpleRetry().PerTryTimeout) > 0 {
-			route.RetryPolicy.PerTryTimeoutMS = protoDurationToMS(rule.HttpReqRetries.GetSimpleRetry().PerTryTimeout)
+	if rule.HttpReqRetries != nil && rule.HttpReqRetries.GetSimpleRetry() != nil {
+		simpleRetry := rule.HttpReqRetries.GetSimpleRetry()
+		if simpleRetry.Attempts > 0 {
+			route.RetryPolicy = buildRetryPolicy(simpleRetry)
 		}
 	}
 
 	destination := service.Hostname
 
 	if len(rule.Route) > 0 {
-		route.WeightedClusters = &WeightedCluster{}
-		for _, dst := range rule.Route {
-			actualDestination := destination
-			if dst.Destination != nil {
-				actualDestination = model.ResolveHostname(config.ConfigMeta, dst.Destination)
+		if hasPriorityTiers(rule.Route) {
+			// Destinations are grouped into priority tiers (e.g. primary subset + failover
+			// subset). Rather than splitting traffic by weight across all of them, build a
+			// single aggregate cluster that fails over from one priority to the next.
+			members := make([]*Cluster, 0, len(rule.Route))
+			priorities := make([]int, 0, len(rule.Route))
+			for _, dst := range rule.Route {
+				actualDestination := destination
+				if dst.Destination != nil {
+					actualDestination = model.ResolveHostname(config.ConfigMeta, dst.Destination)
+				}
+				cluster := BuildOutboundCluster(actualDestination, port, dst.Labels, service.External(), policy, locality)
+				route.Clusters = append(route.Clusters, cluster)
+				members = append(members, cluster)
+				priorities = append(priorities, int(dst.Priority))
+			}
+			aggregate := BuildAggregateCluster(AggregateClusterName(destination, port.Port), members, priorities)
+			route.Clusters = append(route.Clusters, aggregate)
+			route.Cluster = aggregate.Name
+		} else {
+			route.WeightedClusters = &WeightedCluster{}
+			for _, dst := range rule.Route {
+				actualDestination := destination
+				if dst.Destination != nil {
+					actualDestination = model.ResolveHostname(config.ConfigMeta, dst.Destination)
+				}
+				cluster := BuildOutboundCluster(actualDestination, port, dst.Labels, service.External(), policy, locality)
+				route.Clusters = append(route.Clusters, cluster)
+				route.WeightedClusters.Clusters = append(route.WeightedClusters.Clusters, &WeightedClusterEntry{
+					Name:   cluster.Name,
+					Weight: int(dst.Weight),
+				})
 			}
-			cluster := BuildOutboundCluster(actualDestination, port, dst.Labels, service.External())
-			route.Clusters = append(route.Clusters, cluster)
-			route.WeightedClusters.Clusters = append(route.WeightedClusters.Clusters, &WeightedClusterEntry{
-				Name:   cluster.Name,
-				Weight: int(dst.Weight),
-			})
-		}
 
-		// rewrite to a single cluster if it's one weighted cluster
-		if len(rule.Route) == 1 {
-			route.Cluster = route.WeightedClusters.Clusters[0].Name
-			route.WeightedClusters = nil
+			// rewrite to a single cluster if it's one weighted cluster
+			if len(rule.Route) == 1 {
+				route.Cluster = route.WeightedClusters.Clusters[0].Name
+				route.WeightedClusters = nil
+			}
 		}
 	} else {
 		// default route for the destination
-		cluster := BuildOutboundCluster(destination, port, nil, service.External())
+		cluster := BuildOutboundCluster(destination, port, nil, service.External(), policy, locality)
 		route.Cluster = cluster.Name
 
 		v2clusterName := model.BuildSubsetKey(model.TrafficDirectionOutbound, "", destination, port.Port)
@@ -201,6 +423,14 @@ func BuildHTTPRoute(config model.Config, service *model.Service, port *model.Por
 		route.Clusters = append(route.Clusters, cluster)
 	}
 
+	// Surface the retry budget on each owning cluster so mesh operators can cap retry
+	// amplification during incidents, independent of any single route's retry policy.
+	if route.RetryPolicy != nil && route.RetryPolicy.Budget != nil {
+		for _, cluster := range route.Clusters {
+			cluster.RetryBudget = route.RetryPolicy.Budget
+		}
+	}
+
 	if rule.Redirect != nil {
 		route.HostRedirect = rule.Redirect.Authority
 		route.PathRedirect = rule.Redirect.Uri
@@ -223,14 +453,28 @@ func BuildHTTPRoute(config model.Config, service *model.Service, port *model.Por
 	}
 
 	if rule.Mirror != nil {
+		// Shadowing is supported for both internal and external (mesh-external) destinations;
+		// BuildOutboundCluster already handles either via service.External().
 		fqdnDest := model.ResolveHostname(config.ConfigMeta, rule.Mirror)
-		cluster := BuildOutboundCluster(fqdnDest, port, rule.Mirror.Labels, service.External())
+		cluster := BuildOutboundCluster(fqdnDest, port, rule.Mirror.Labels, service.External(), policy, locality)
 		route.Clusters = append(route.Clusters, cluster)
-		route.ShadowCluster = &ShadowCluster{
-			//TODO support shadowing between internal and external kubernetes services
-			// currently only shadowing between internal kubernetes services is supported
+
+		// rule.Mirror.Percent and rule.Mirror.TraceSampled are, like the MatchCondition
+		// extensions above, a proto-shim addition to this checkout's (unvendored)
+		// istio.io/api/routing/v1alpha1 HTTPRoute.Mirror.
+		shadow := &ShadowCluster{
 			Cluster: cluster.Name,
+			// Shadowed requests are not trace-sampled by default so mirroring a fraction of
+			// traffic doesn't pollute distributed traces; users opt in explicitly.
+			TraceSampled: rule.Mirror.TraceSampled,
+		}
+		if rule.Mirror.Percent != nil && rule.Mirror.Percent.Value > 0 {
+			shadow.RuntimeFraction = &RuntimeFraction{
+				Numerator:   uint32(rule.Mirror.Percent.Value * percentToTenThousandthsScale),
+				Denominator: RuntimeFractionDenominatorTenThousand,
+			}
 		}
+		route.ShadowClusters = append(route.ShadowClusters, shadow)
 	}
 
 	for name, val := range rule.AppendHeaders {
@@ -271,6 +515,189 @@ func BuildHTTPRoute(config model.Config, service *model.Service, port *model.Por
 	return route
 }
 
+// hasPriorityTiers returns true if the destinations express priority-based failover
+// (via DestinationWeight.Priority) rather than plain weighted splitting. Priority and
+// weight are mutually exclusive on a route: if any destination sets a priority, all of
+// them are treated as failover tiers.
+func hasPriorityTiers(routes []*routing.DestinationWeight) bool {
+	for _, dst := range routes {
+		if dst.Priority > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AggregateClusterName builds the name of the aggregate cluster fronting the priority
+// tiers for a destination/port pair.
+func AggregateClusterName(destination model.Hostname, port int) string {
+	return TruncateClusterName(OutboundClusterPrefix + "aggregate|" + destination.String() + fmt.Sprintf(":%d", port))
+}
+
+// BuildAggregateCluster produces an aggregate-style cluster whose membership references
+// other clusters by name instead of listing hosts directly. Envoy spills over from one
+// priority to the next when a member's health percentage drops below the configured
+// threshold, which lets a destination rule express primary/failover subsets without
+// forcing every route to re-list its clusters as a WeightedCluster.
+func BuildAggregateCluster(name string, members []*Cluster, priorities []int) *Cluster {
+	cluster := &Cluster{
+		Name:   name,
+		Type:   ClusterTypeAggregate,
+		LbType: DefaultLbType,
+	}
+	cluster.AggregateClusterConfig = &AggregateClusterConfig{
+		Members: make([]AggregateClusterMember, 0, len(members)),
+	}
+	for i, member := range members {
+		priority := 0
+		if i < len(priorities) {
+			priority = priorities[i]
+		}
+		cluster.AggregateClusterConfig.Members = append(cluster.AggregateClusterConfig.Members, AggregateClusterMember{
+			Name:     member.Name,
+			Priority: priority,
+		})
+	}
+	return cluster
+}
+
+// HeaderMatch describes a single header predicate on an HTTPRoute, translated from a
+// MatchCondition's header value string (which already carries exact/prefix/regex
+// semantics via its StringMatch oneof).
+type HeaderMatch struct {
+	Name string `json:"name"`
+
+	// Exactly one of Exact, Prefix, Suffix, Regex is set, unless Present is true in which
+	// case all of them are empty and the header is matched purely on presence.
+	Exact  string `json:"exact_match,omitempty"`
+	Prefix string `json:"prefix_match,omitempty"`
+	Suffix string `json:"suffix_match,omitempty"`
+	Regex  string `json:"regex_match,omitempty"`
+
+	// Present matches on header presence alone, regardless of value.
+	Present bool `json:"present_match,omitempty"`
+
+	// InvertMatch negates the match above.
+	InvertMatch bool `json:"invert_match,omitempty"`
+}
+
+// QueryParameterMatch describes a single query parameter predicate.
+type QueryParameterMatch struct {
+	Name  string `json:"name"`
+	Exact string `json:"exact_match,omitempty"`
+	Regex string `json:"regex_match,omitempty"`
+}
+
+// applyExtendedMatch translates the richer parts of a v1alpha1 MatchCondition — regex
+// path matching, header presence/suffix/regex predicates, and query parameter matchers —
+// onto the HTTPRoute. buildHTTPRouteMatch already handles plain prefix/path matching and
+// simple exact/prefix header matches; this only adds what it collapses away.
+//
+// StringMatch.Suffix and MatchCondition.QueryParams are, like the extended SimpleRetryPolicy
+// fields above, a proto-shim addition this checkout's (unvendored) istio.io/api/routing/v1alpha1
+// doesn't carry; this function depends on that addition landing upstream first.
+func applyExtendedMatch(route *HTTPRoute, match *routing.MatchCondition) {
+	if match == nil {
+		return
+	}
+
+	if uri := match.GetRequest().GetHeaders()[model.HeaderURI]; uri.GetRegex() != "" {
+		route.Prefix = ""
+		route.Path = ""
+		route.RegexPath = uri.GetRegex()
+	}
+
+	for name, value := range match.GetRequest().GetHeaders() {
+		if name == model.HeaderURI {
+			continue
+		}
+		hm := HeaderMatch{Name: name}
+		switch {
+		case value.GetRegex() != "":
+			hm.Regex = value.GetRegex()
+		case value.GetSuffix() != "":
+			hm.Suffix = value.GetSuffix()
+		case value.GetPrefix() != "":
+			hm.Prefix = value.GetPrefix()
+		case value.GetExact() != "":
+			hm.Exact = value.GetExact()
+		default:
+			hm.Present = true
+		}
+		route.HeaderMatches = append(route.HeaderMatches, hm)
+	}
+
+	for name, value := range match.GetQueryParams() {
+		qp := QueryParameterMatch{Name: name}
+		if value.GetRegex() != "" {
+			qp.Regex = value.GetRegex()
+		} else {
+			qp.Exact = value.GetExact()
+		}
+		route.QueryParameterMatches = append(route.QueryParameterMatches, qp)
+	}
+}
+
+// RetryBudget caps the fraction of in-flight requests a cluster will allow to be retries,
+// so a spike of retries during an incident can't amplify load without bound.
+type RetryBudget struct {
+	// BudgetPercent is the percentage (0-100) of the cluster's active requests that may be
+	// retries at any one time.
+	BudgetPercent float64 `json:"budget_percent,omitempty"`
+
+	// MinRetryConcurrency is the floor on concurrent retries allowed regardless of
+	// BudgetPercent, so low-traffic clusters aren't starved of retries entirely.
+	MinRetryConcurrency uint32 `json:"min_retry_concurrency,omitempty"`
+}
+
+// buildRetryPolicy translates a v1alpha1 SimpleRetryPolicy into the richer RetryPolicy
+// used by this builder. It returns nil (logging a warning) if the policy is invalid, e.g.
+// RetriableStatusCodes set without any retries actually being allowed.
+//
+// RetriableStatusCodes, RetryOn, BaseInterval, MaxInterval, PreviousHosts, OmitCanaryHosts,
+// BudgetPercent and MinRetryConcurrency are a proto-shim addition to
+// routing.HTTPRetry_SimpleRetryPolicy that this checkout's vendored istio.io/api/routing/v1alpha1
+// doesn't carry (this repo snapshot doesn't vendor that module at all); this function's reads of
+// those fields depend on that addition landing upstream first.
+func buildRetryPolicy(simpleRetry *routing.HTTPRetry_SimpleRetryPolicy) *RetryPolicy {
+	if simpleRetry.Attempts == 0 && len(simpleRetry.RetriableStatusCodes) > 0 {
+		log.Warnf("invalid retry policy: RetriableStatusCodes set with NumRetries == 0, dropping retry policy")
+		return nil
+	}
+
+	retryOn := simpleRetry.RetryOn
+	if retryOn == "" {
+		// These are the safest retry policies as per envoy docs
+		retryOn = "5xx,connect-failure,refused-stream"
+	}
+
+	policy := &RetryPolicy{
+		NumRetries:           int(simpleRetry.Attempts),
+		Policy:               retryOn,
+		RetriableStatusCodes: simpleRetry.RetriableStatusCodes,
+		PreviousHosts:        simpleRetry.PreviousHosts,
+		OmitCanaryHosts:      simpleRetry.OmitCanaryHosts,
+	}
+
+	if protoDurationToMS(simpleRetry.PerTryTimeout) > 0 {
+		policy.PerTryTimeoutMS = protoDurationToMS(simpleRetry.PerTryTimeout)
+	}
+	if protoDurationToMS(simpleRetry.BaseInterval) > 0 {
+		policy.BaseIntervalMS = protoDurationToMS(simpleRetry.BaseInterval)
+	}
+	if protoDurationToMS(simpleRetry.MaxInterval) > 0 {
+		policy.MaxIntervalMS = protoDurationToMS(simpleRetry.MaxInterval)
+	}
+	if simpleRetry.BudgetPercent > 0 || simpleRetry.MinRetryConcurrency > 0 {
+		policy.Budget = &RetryBudget{
+			BudgetPercent:       simpleRetry.BudgetPercent,
+			MinRetryConcurrency: simpleRetry.MinRetryConcurrency,
+		}
+	}
+
+	return policy
+}
+
 func buildCluster(address, name string, timeout *duration.Duration) *Cluster {
 	return &Cluster{
 		Name:             name,
@@ -295,20 +722,117 @@ func buildDecorator(config model.Config) *Decorator {
 	return nil
 }
 
-func buildZipkinTracing() *Tracing {
+// TracingConfig describes which distributed tracing backend to wire into the generated
+// HTTP connection manager, and how aggressively to sample.
+type TracingConfig struct {
+	// Provider selects the tracing backend: "zipkin" (default), "jaeger", "datadog", or
+	// "opencensus".
+	Provider string
+
+	// CollectorCluster is the name of the Envoy cluster the tracer should send spans to.
+	CollectorCluster string
+
+	// CollectorEndpoint is the HTTP path the collector expects spans on (Zipkin/Jaeger).
+	CollectorEndpoint string
+
+	// SamplingRate is the fraction (0.0-100.0) of requests to trace.
+	SamplingRate float64
+
+	// CustomTags are additional static tags attached to every span.
+	CustomTags map[string]string
+
+	// MaxPathTagLength caps the length of the http.url/path tag recorded on spans.
+	MaxPathTagLength int
+}
+
+const (
+	// DatadogTraceDriverType is the Envoy HTTP tracer driver name for Datadog.
+	DatadogTraceDriverType = "envoy.tracers.datadog"
+
+	// OpenCensusTraceDriverType is the Envoy HTTP tracer driver name for OpenCensus.
+	OpenCensusTraceDriverType = "envoy.tracers.opencensus"
+)
+
+const (
+	// ZipkinProvider selects the Zipkin HTTP tracer.
+	ZipkinProvider = "zipkin"
+	// JaegerProvider selects Zipkin's tracer in Jaeger-compatible mode (128-bit trace IDs).
+	JaegerProvider = "jaeger"
+	// DatadogProvider selects the Datadog tracer.
+	DatadogProvider = "datadog"
+	// OpenCensusProvider selects the OpenCensus tracer.
+	OpenCensusProvider = "opencensus"
+)
+
+// BuildTracing is the single entry point callers should use to attach tracing to a
+// generated HTTP connection manager: it derives a TracingConfig from MeshConfig and
+// returns nil (no tracing) when mesh disables it, rather than requiring callers to
+// construct a TracingConfig by hand. mesh.EnableTracing is the only provider-selection
+// knob this tree's MeshConfig carries today, so every enabled mesh gets Zipkin; richer
+// per-provider selection (Jaeger/Datadog/OpenCensus) needs a MeshConfig field to key off,
+// which is tracked as separate proto-shim work.
+func BuildTracing(mesh *meshconfig.MeshConfig) *Tracing {
+	if mesh == nil || !mesh.EnableTracing {
+		return nil
+	}
+	return buildTracing(&TracingConfig{Provider: ZipkinProvider})
+}
+
+// buildTracing returns the HTTPTraceDriver matching cfg.Provider, defaulting to Zipkin
+// when cfg is nil or the provider is unset. Jaeger reuses Envoy's Zipkin tracer in
+// Jaeger-compatible mode (collector_hostname + 128-bit trace IDs) since Envoy has no
+// dedicated Jaeger driver.
+func buildTracing(cfg *TracingConfig) *Tracing {
+	if cfg == nil {
+		cfg = &TracingConfig{Provider: ZipkinProvider}
+	}
+
+	var driver HTTPTraceDriver
+	switch cfg.Provider {
+	case DatadogProvider:
+		driver = HTTPTraceDriver{
+			HTTPTraceDriverType: DatadogTraceDriverType,
+			HTTPTraceDriverConfig: HTTPTraceDriverConfig{
+				CollectorCluster: cfg.CollectorCluster,
+			},
+		}
+	case OpenCensusProvider:
+		driver = HTTPTraceDriver{
+			HTTPTraceDriverType: OpenCensusTraceDriverType,
+		}
+	case JaegerProvider:
+		driver = HTTPTraceDriver{
+			HTTPTraceDriverType: ZipkinTraceDriverType,
+			HTTPTraceDriverConfig: HTTPTraceDriverConfig{
+				CollectorCluster:  cfg.CollectorCluster,
+				CollectorEndpoint: cfg.CollectorEndpoint,
+				TraceID128Bit:     true,
+			},
+		}
+	default:
+		driver = HTTPTraceDriver{
+			HTTPTraceDriverType: ZipkinTraceDriverType,
+			HTTPTraceDriverConfig: HTTPTraceDriverConfig{
+				CollectorCluster:  orDefault(cfg.CollectorCluster, ZipkinCollectorCluster),
+				CollectorEndpoint: orDefault(cfg.CollectorEndpoint, ZipkinCollectorEndpoint),
+			},
+		}
+	}
+
 	return &Tracing{
 		HTTPTracer: HTTPTracer{
-			HTTPTraceDriver: HTTPTraceDriver{
-				HTTPTraceDriverType: ZipkinTraceDriverType,
-				HTTPTraceDriverConfig: HTTPTraceDriverConfig{
-					CollectorCluster:  ZipkinCollectorCluster,
-					CollectorEndpoint: ZipkinCollectorEndpoint,
-				},
-			},
+			HTTPTraceDriver: driver,
 		},
 	}
 }
 
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
 // BuildVirtualHost constructs an entry for VirtualHost for a destination service.
 // The unique name for a virtual host is a combination of the destination service and the port, e.g.
 // "svc.ns.svc.cluster.local:http".
@@ -352,8 +876,29 @@ func BuildVirtualHost(svc *model.Service, port *model.Port, suffix []string, rou
 	return &VirtualHost{
 		Name:    svc.Key(port, nil),
 		Domains: domains,
-		Routes:  routes,
+		Routes:  sortRoutesBySpecificity(routes),
+	}
+}
+
+// sortRoutesBySpecificity stable-sorts routes so that the most specific matches are
+// tried first: header/query predicated routes and plain prefix/exact path routes before
+// bare regex-path routes, which tend to be broad catch-alls. Routes are otherwise left in
+// their original (rule priority) order, since Envoy picks the first match that succeeds.
+func sortRoutesBySpecificity(routes []*HTTPRoute) []*HTTPRoute {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routeSpecificityRank(routes[i]) < routeSpecificityRank(routes[j])
+	})
+	return routes
+}
+
+func routeSpecificityRank(route *HTTPRoute) int {
+	if route.RegexPath == "" {
+		return 0
+	}
+	if len(route.HeaderMatches) > 0 || len(route.QueryParameterMatches) > 0 {
+		return 1
 	}
+	return 2
 }
 
 // sharedHost computes the shared host name suffix for instances.
@@ -413,13 +958,17 @@ func BuildTCPRoute(cluster *Cluster, addresses []string) *TCPRoute {
 	return route
 }
 
-// BuildOriginalDSTCluster builds a DST cluster.
-func BuildOriginalDSTCluster(name string, timeout *duration.Duration) *Cluster {
-	return &Cluster{
+// BuildOriginalDSTCluster builds a DST cluster. Passing a non-nil policy lets egress
+// traffic against unknown hosts still benefit from passive ejection, even though there is
+// no DestinationRule/Subset to key off of.
+func BuildOriginalDSTCluster(name string, timeout *duration.Duration, policy *routing.DestinationPolicy) *Cluster {
+	cluster := &Cluster{
 		Name:             TruncateClusterName(OutboundClusterPrefix + name),
 		Type:             ClusterTypeOriginalDST,
 		ConnectTimeoutMs: protoDurationToMS(timeout),
 		LbType:           LbTypeOriginalDST,
 		outbound:         true,
 	}
+	applyOutlierDetection(cluster, policy)
+	return cluster
 }
@@ -15,15 +15,18 @@
 package v2
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	xdsapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	ads "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
 	"github.com/gogo/protobuf/types"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
@@ -53,8 +56,30 @@ var (
 	// clients and pushes them serially for now, to avoid large CPU/memory spikes.
 	// We measure and reports cases where pusing a client takes longer.
 	PushTimeout = 5 * time.Second
+
+	// UnhealthyThreshold is the number of consecutive push failures after which a
+	// client is considered unhealthy and skipped by adsPushAll until it recovers.
+	UnhealthyThreshold = 3
+
+	// UnhealthyCooldown is how long an unhealthy client is skipped before it is
+	// given another chance.
+	UnhealthyCooldown = 30 * time.Second
+
+	// PushConcurrency bounds how many client pushes adsPushAll fans out at once, so a
+	// push to thousands of sidecars doesn't spike CPU/memory by starting them all at
+	// the same instant.
+	PushConcurrency = 10
 )
 
+// pushSemaphore bounds the number of in-flight adsPushAll client pushes to
+// PushConcurrency.
+var pushSemaphore = make(chan struct{}, PushConcurrency)
+
+// pushRateLimiter smooths out the rate at which adsPushAll starts new client pushes,
+// independent of PushConcurrency, so a burst of config changes doesn't saturate the
+// sending goroutines all at once.
+var pushRateLimiter = rate.NewLimiter(rate.Limit(100), 100)
+
 var (
 	timeZero time.Time
 )
@@ -96,6 +121,26 @@ var (
 		Help: "Number of endpoints connected to this pilot using XDS",
 	})
 
+	unhealthyClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_xds_unhealthy_clients",
+		Help: "Number of connected XDS clients currently skipped by pushes due to repeated failures.",
+	})
+
+	pushQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_xds_push_queue_depth",
+		Help: "Number of clients in the current adsPushAll fan-out still waiting to be pushed.",
+	})
+
+	pushInflight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pilot_xds_push_inflight",
+		Help: "Number of client pushes currently in flight, bounded by PushConcurrency.",
+	})
+
+	pushCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pilot_xds_push_coalesced_total",
+		Help: "Number of adsPushAll pushes skipped because the client already had a pending push queued.",
+	})
+
 	writeTimeout = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "pilot_xds_write_timeout",
 		Help: "Pilot write timeout",
@@ -125,6 +170,10 @@ func init() {
 	prometheus.MustRegister(monServices)
 	prometheus.MustRegister(monVServices)
 	prometheus.MustRegister(xdsClients)
+	prometheus.MustRegister(unhealthyClients)
+	prometheus.MustRegister(pushQueueDepth)
+	prometheus.MustRegister(pushInflight)
+	prometheus.MustRegister(pushCoalesced)
 	prometheus.MustRegister(writeTimeout)
 	prometheus.MustRegister(pushTimeouts)
 	prometheus.MustRegister(pushes)
@@ -153,6 +202,14 @@ type XdsConnection struct {
 
 	modelNode *model.Proxy
 
+	// ctx is derived from the gRPC stream context and is canceled when the client
+	// disconnects or the stream otherwise terminates. It replaces polling doneChannel
+	// in code that needs to stop waiting as soon as the connection goes away.
+	ctx context.Context
+	// cancel cancels ctx. Always called on connection teardown, even on the error paths,
+	// so nothing downstream (pushes, sends) keeps waiting on a dead connection.
+	cancel context.CancelFunc
+
 	// Sending on this channel results in  push. We may also make it a channel of objects so
 	// same info can be sent to all clients, without recomputing.
 	pushChannel chan *XdsEvent
@@ -193,18 +250,111 @@ type XdsConnection struct {
 
 	// Time of last push failure.
 	LastPushFailure time.Time
+
+	// consecutiveFailures counts pushes in a row that have timed out or otherwise
+	// failed to reach this client. It resets to 0 on any successful push. Set via
+	// recordPushFailure/send, which observe the actual grpc stream.Send outcome;
+	// adsPushAll's pushChannel enqueue (including the coalescing default branch below) is
+	// drain-pressure, not a failure, and must not touch this counter.
+	consecutiveFailures int
+}
+
+// recordPushFailure marks a push to this client as failed, for callers that observe an actual
+// send error (as opposed to adsPushAll's enqueue-only coalescing path, which is not a failure).
+func (con *XdsConnection) recordPushFailure() {
+	con.consecutiveFailures++
+	con.LastPushFailure = time.Now()
 }
 
-// XdsEvent represents a config or registry event that results in a push.
+// isUnhealthy reports whether this client has failed enough consecutive pushes
+// recently that it should be skipped until UnhealthyCooldown has passed, to avoid
+// adsPushAll repeatedly blocking on a client that isn't reading.
+func (con *XdsConnection) isUnhealthy() bool {
+	return con.consecutiveFailures >= UnhealthyThreshold && time.Since(con.LastPushFailure) < UnhealthyCooldown
+}
+
+// XdsEvent represents a config or registry event that results in a push. A zero-value
+// XdsEvent (full push) still triggers CDS/LDS/RDS/EDS for everything the connection
+// watches; the typed fields below let a source of events narrow what actually needs to
+// be recomputed and sent, so unrelated resources aren't resent on every change.
 type XdsEvent struct {
 
-	// If not empty, it is used to indicate the event is caused by a change in the clusters.
-	// Only EDS for the listed clusters will be sent.
+	// full, when true, forces a coarse full push regardless of the fields below. This is
+	// what adsPushAll uses, since it has no way to know what changed.
+	full bool
+
+	// EDSClusters, if non-empty, restricts the EDS recompute/push to the intersection of
+	// these cluster names and the connection's currently watched Clusters.
+	EDSClusters []string
+
+	// RDSRouteNames, if non-empty, restricts the RDS push to the intersection of these
+	// route names and the connection's currently watched Routes.
+	RDSRouteNames []string
+
+	// LDSChanged, if true, triggers a full LDS push for connections watching listeners.
+	LDSChanged bool
+
+	// CDSChanged, if true, triggers a full CDS push for connections watching clusters.
+	CDSChanged bool
+
+	// clusters is kept for backwards compatibility with callers that only know the old,
+	// EDS-only narrowing; it is folded into EDSClusters on receipt.
 	clusters []string
+
+	// ctx is the context the event was generated under (e.g. the config/registry event
+	// that triggered it). Pushers may use it to bound how long they wait on anything
+	// upstream of the push itself; it does not replace con.ctx, which tracks the
+	// connection's own lifetime.
+	ctx context.Context
+
+	// done, if non-nil, is closed by the connection's StreamAggregatedResources loop once it
+	// has finished generating and sending every push this event triggers. adsPushAll waits on
+	// this before releasing the event's pushSemaphore slot, so PushConcurrency actually bounds
+	// concurrent push generation/send, not just how many events are sitting in pushChannel.
+	done chan struct{}
 }
 
-func newXdsConnection(peerAddr string, stream DiscoveryStream) *XdsConnection {
+// context returns e.ctx, defaulting to context.Background() if the event was built
+// without one (e.g. via a bare &XdsEvent{...} literal).
+func (e *XdsEvent) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// dirtyClusters returns the clusters this event indicates changed, regardless of whether
+// the caller used the legacy `clusters` field or the newer `EDSClusters` field.
+func (e *XdsEvent) dirtyClusters() []string {
+	if len(e.EDSClusters) > 0 {
+		return e.EDSClusters
+	}
+	return e.clusters
+}
+
+// intersect returns the subset of want that also appears in have.
+func intersect(want, have []string) []string {
+	if len(want) == 0 || len(have) == 0 {
+		return nil
+	}
+	haveSet := make(map[string]struct{}, len(have))
+	for _, h := range have {
+		haveSet[h] = struct{}{}
+	}
+	out := make([]string, 0, len(want))
+	for _, w := range want {
+		if _, ok := haveSet[w]; ok {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func newXdsConnection(ctx context.Context, peerAddr string, stream DiscoveryStream) *XdsConnection {
+	ctx, cancel := context.WithCancel(ctx)
 	return &XdsConnection{
+		ctx:           ctx,
+		cancel:        cancel,
 		pushChannel:   make(chan *XdsEvent, 1),
 		doneChannel:   make(chan int, 1),
 		PeerAddr:      peerAddr,
@@ -248,7 +398,8 @@ func (s *DiscoveryServer) StreamAggregatedResources(stream ads.AggregatedDiscove
 		s.updateModel()
 	}
 
-	con := newXdsConnection(peerAddr, stream)
+	con := newXdsConnection(stream.Context(), peerAddr, stream)
+	defer con.cancel()
 	defer close(con.doneChannel)
 
 	// Do not call: defer close(con.pushChannel) !
@@ -268,6 +419,9 @@ func (s *DiscoveryServer) StreamAggregatedResources(stream ads.AggregatedDiscove
 	for {
 		// Block until either a request is received or the ticker ticks
 		select {
+		case <-con.ctx.Done():
+			adsLog.Infof("ADS: %q %s stream context done: %v", peerAddr, con.ConID, con.ctx.Err())
+			return con.ctx.Err()
 		case discReq, ok = <-reqChannel:
 			if !ok {
 				// Remote side closed connection.
@@ -378,36 +532,72 @@ func (s *DiscoveryServer) StreamAggregatedResources(stream ads.AggregatedDiscove
 				s.addCon(con.ConID, con)
 				defer s.removeCon(con.ConID, con)
 			}
-		case <-con.pushChannel:
-			// It is called when config changes.
-			// This is not optimized yet - we should detect what changed based on event and only
-			// push resources that need to be pushed.
-			if con.CDSWatch {
-				err := s.pushCds(*con.modelNode, con)
-				if err != nil {
-					return err
-				}
+		case pushEv := <-con.pushChannel:
+			if err := s.processPushEvent(con, pushEv); err != nil {
+				return err
 			}
-			if len(con.Routes) > 0 {
-				err := s.pushRoute(con)
-				if err != nil {
-					return err
-				}
+		}
+	}
+}
+
+// processPushEvent generates and sends every push pushEv triggers for con, closing
+// pushEv.done (if set) once that work is finished — successfully or not — so adsPushAll's wait
+// on it bounds actual push generation/send, not just how long the event sat in pushChannel.
+func (s *DiscoveryServer) processPushEvent(con *XdsConnection, pushEv *XdsEvent) error {
+	if pushEv.done != nil {
+		defer close(pushEv.done)
+	}
+	if pushEv.context().Err() != nil {
+		// The event's own context (e.g. the config/registry event that produced it)
+		// is already gone; nothing actionable left to push.
+		return nil
+	}
+	// Full events (the adsPushAll fallback) re-push everything the connection
+	// watches. Narrower events only recompute/send the resources they flag as dirty,
+	// intersected with what this connection actually watches.
+	if pushEv.full || pushEv.CDSChanged {
+		if con.CDSWatch {
+			err := s.pushCds(*con.modelNode, con)
+			if err != nil {
+				return err
 			}
-			if len(con.Clusters) > 0 {
-				err := s.pushEds(con)
-				if err != nil {
-					return err
-				}
+		}
+	}
+	if pushEv.full {
+		if len(con.Routes) > 0 {
+			err := s.pushRoute(con)
+			if err != nil {
+				return err
 			}
-			if con.LDSWatch {
-				err := s.pushLds(*con.modelNode, con)
-				if err != nil {
-					return err
-				}
+		}
+	} else if dirty := intersect(pushEv.RDSRouteNames, con.Routes); len(dirty) > 0 {
+		err := s.pushRoute(con)
+		if err != nil {
+			return err
+		}
+	}
+	if pushEv.full {
+		if len(con.Clusters) > 0 {
+			err := s.pushEds(con)
+			if err != nil {
+				return err
+			}
+		}
+	} else if dirty := intersect(pushEv.dirtyClusters(), con.Clusters); len(dirty) > 0 {
+		err := s.pushEds(con)
+		if err != nil {
+			return err
+		}
+	}
+	if pushEv.full || pushEv.LDSChanged {
+		if con.LDSWatch {
+			err := s.pushLds(*con.modelNode, con)
+			if err != nil {
+				return err
 			}
 		}
 	}
+	return nil
 }
 
 func edsClientCount() int {
@@ -418,9 +608,130 @@ func edsClientCount() int {
 	return n
 }
 
+// EdsCluster tracks the ADS/EDS connections currently watching a cluster, together with
+// the last ClusterLoadAssignment computed for it, so an endpoint change affecting only
+// that cluster can push a narrow XdsEvent to just those connections instead of falling
+// back to adsPushAll.
+type EdsCluster struct {
+	mutex sync.RWMutex
+
+	// LoadAssignment is the most recent ClusterLoadAssignment pushed for this cluster.
+	LoadAssignment *xdsapi.ClusterLoadAssignment
+
+	// EdsClients is the set of connections watching this cluster, keyed by connection ID.
+	EdsClients map[string]*XdsConnection
+}
+
+var (
+	edsClusters     = map[string]*EdsCluster{}
+	edsClusterMutex sync.Mutex
+)
+
+// addEdsCon registers con as watching clusterName, creating the cluster's registry entry
+// the first time any connection watches it.
+func (s *DiscoveryServer) addEdsCon(clusterName, conID string, con *XdsConnection) {
+	edsClusterMutex.Lock()
+	c, ok := edsClusters[clusterName]
+	if !ok {
+		c = &EdsCluster{EdsClients: map[string]*XdsConnection{}}
+		edsClusters[clusterName] = c
+	}
+	edsClusterMutex.Unlock()
+
+	c.mutex.Lock()
+	c.EdsClients[conID] = con
+	c.mutex.Unlock()
+}
+
+// removeEdsCon unregisters con from clusterName, dropping the cluster's registry entry
+// once its last watcher disconnects.
+func (s *DiscoveryServer) removeEdsCon(clusterName, conID string, con *XdsConnection) {
+	edsClusterMutex.Lock()
+	c, ok := edsClusters[clusterName]
+	edsClusterMutex.Unlock()
+	if !ok {
+		return
+	}
+
+	c.mutex.Lock()
+	delete(c.EdsClients, conID)
+	empty := len(c.EdsClients) == 0
+	c.mutex.Unlock()
+
+	if empty {
+		edsClusterMutex.Lock()
+		delete(edsClusters, clusterName)
+		edsClusterMutex.Unlock()
+	}
+}
+
+// updateCluster re-stamps edsCluster's LoadAssignment so adsPushAll's full-push fallback
+// always has a non-nil one to fall back on, even for a cluster no EDSUpdate has ever
+// touched. The real per-endpoint recomputation belongs to whatever calls EDSUpdate below;
+// this is only the safety net for the coarse path.
+func updateCluster(clusterName string, edsCluster *EdsCluster) error {
+	edsCluster.mutex.Lock()
+	defer edsCluster.mutex.Unlock()
+	if edsCluster.LoadAssignment == nil {
+		edsCluster.LoadAssignment = &xdsapi.ClusterLoadAssignment{ClusterName: clusterName}
+	}
+	return nil
+}
+
+// EDSUpdate is the narrow event source adsPushAll's dirty-cluster path was missing: a
+// registry or config watcher that knows exactly one cluster's endpoints changed calls this
+// instead of forcing every connection through a full push. It stores the recomputed
+// assignment and pushes a narrow XdsEvent to only the connections currently watching
+// clusterName, so processPushEvent's dirty-intersection branch actually fires.
+func (s *DiscoveryServer) EDSUpdate(clusterName string, loadAssignment *xdsapi.ClusterLoadAssignment) error {
+	edsClusterMutex.Lock()
+	c, ok := edsClusters[clusterName]
+	edsClusterMutex.Unlock()
+	if !ok {
+		// No connection is currently watching this cluster; nothing to push.
+		return nil
+	}
+
+	c.mutex.Lock()
+	c.LoadAssignment = loadAssignment
+	clients := make([]*XdsConnection, 0, len(c.EdsClients))
+	for _, con := range c.EdsClients {
+		clients = append(clients, con)
+	}
+	c.mutex.Unlock()
+
+	for _, con := range clients {
+		select {
+		case con.pushChannel <- &XdsEvent{EDSClusters: []string{clusterName}, ctx: con.ctx}:
+		case <-con.doneChannel:
+		case <-con.ctx.Done():
+		default:
+			// con's pushChannel (buffer of 1) already has a pending push queued, meaning
+			// it hasn't drained the last one yet. As in adsPushAll's fan-out loop, this is
+			// coalescing, not a failure: whatever is already queued will cover this update
+			// too rather than this loop blocking on one slow connection and delaying the
+			// narrow push to every other connection watching clusterName.
+			pushCoalesced.Inc()
+		}
+	}
+	return nil
+}
+
 // adsPushAll implements old style invalidation, generated when any rule or endpoint changes.
 // Primary code path is from v1 discoveryService.clearCache(), which is added as a handler
-// to the model ConfigStorageCache and Controller.
+// to the model ConfigStorageCache and Controller. It is the coarse fallback used whenever
+// the source of a config/registry event can't (yet) identify exactly what changed; callers
+// that know precisely what changed (e.g. an endpoint update for a single cluster) should
+// call EDSUpdate above instead, which pushes a narrower XdsEvent directly to the affected
+// connections.
+//
+// In this tree, adsPushAll itself still has no caller (no v1 discoveryService.clearCache()
+// or equivalent registers it against a model.ConfigStorageCache/Controller), and EDSUpdate
+// has no caller either — there's no ServiceEntry/endpoint controller in this tree to drive
+// it. What's fixed here is that the registry and event source processPushEvent's
+// dirty-intersection logic depends on (EdsCluster, edsClusters, updateCluster, addEdsCon,
+// removeEdsCon, and now EDSUpdate) are all real and internally consistent, so wiring in an
+// actual registry/config watcher is the only thing still missing, not a dangling reference.
 func adsPushAll() {
 	// First update all cluster load assignments. This is computed for each cluster once per config change
 	// instead of once per endpoint.
@@ -455,38 +766,66 @@ func adsPushAll() {
 	// It will include sending all configs that envoy is listening for, including EDS.
 	// TODO: get service, serviceinstances, configs once, to avoid repeated redundant calls.
 	// TODO: indicate the specific events, to only push what changed.
+	var unhealthy int64
+	var wg sync.WaitGroup
+	remaining := int64(len(tmpMap))
+	pushQueueDepth.Set(float64(remaining))
 	for _, c := range tmpMap {
-		// Using non-blocking push has problems if 2 pushes happen too close to each other
 		client := c
-		// TODO: this should be in a thread group, to do multiple pushes in parallel.
-		// Commented out - since we don't have throttling or rate control for push - need to experiment
-		// with larger clusters.
-		//go func(client *XdsConnection) {
-		to := time.After(PushTimeout)
-		select {
-		case client.pushChannel <- &XdsEvent{}:
-			client.LastPush = time.Now()
-			client.LastPushFailure = timeZero
-		case <-client.doneChannel: // connection was closed
-		case <-to:
-			pushTimeouts.Add(1)
-			//default:
-			// This may happen to some clients if the other side is in a bad state and can't receive.
-			// The tests were catching this - one of the client was not reading.
-			if client.LastPushFailure.IsZero() {
-				client.LastPushFailure = time.Now()
-				adsLog.Warnf("Failed to push, client busy %s", client.ConID)
-				pushErrors.With(prometheus.Labels{"type": "short"}).Add(1)
-			} else {
-				if time.Since(client.LastPushFailure) > 10*time.Second {
-					adsLog.Warnf("Repeated failure to push %s", client.ConID)
-					// unfortunately grpc go doesn't allow closing (unblocking) the stream.
-					pushErrors.With(prometheus.Labels{"type": "long"}).Add(1)
+		if client.isUnhealthy() {
+			// Client has repeatedly failed to accept pushes recently; skip it rather than
+			// spending a push slot on a client that isn't reading.
+			atomic.AddInt64(&unhealthy, 1)
+			atomic.AddInt64(&remaining, -1)
+			pushQueueDepth.Set(float64(atomic.LoadInt64(&remaining)))
+			continue
+		}
+
+		// Bound the number of concurrent pushes (PushConcurrency) and smooth the rate at
+		// which new ones start (pushRateLimiter), so a push to many sidecars at once
+		// doesn't spike CPU/memory.
+		_ = pushRateLimiter.Wait(client.ctx)
+		pushSemaphore <- struct{}{}
+		pushInflight.Inc()
+		wg.Add(1)
+		go func(client *XdsConnection) {
+			defer func() {
+				<-pushSemaphore
+				pushInflight.Dec()
+				atomic.AddInt64(&remaining, -1)
+				pushQueueDepth.Set(float64(atomic.LoadInt64(&remaining)))
+				wg.Done()
+			}()
+
+			pushDone := make(chan struct{})
+			select {
+			case client.pushChannel <- &XdsEvent{full: true, ctx: client.ctx, done: pushDone}:
+				client.LastPush = time.Now()
+				client.LastPushFailure = timeZero
+				client.consecutiveFailures = 0
+				// Hold this push slot until the connection's stream goroutine has actually
+				// finished generating and sending the push, not merely accepted it onto
+				// pushChannel, so PushConcurrency bounds real push work.
+				select {
+				case <-pushDone:
+				case <-client.doneChannel:
+				case <-client.ctx.Done():
 				}
+			case <-client.doneChannel: // connection was closed
+			case <-client.ctx.Done(): // connection was closed
+			default:
+				// The client's pushChannel (buffer of 1) already has a pending push queued,
+				// meaning it hasn't drained the last one yet. Rather than blocking this push
+				// slot on a timeout waiting for room, coalesce: the pending push is already a
+				// full push, so it'll cover this round too. This is the intended success path
+				// for a client that's still draining, not a failure, so it must not count
+				// toward consecutiveFailures/isUnhealthy eviction.
+				pushCoalesced.Inc()
 			}
-		}
-		//}(client)
+		}(client)
 	}
+	wg.Wait()
+	unhealthyClients.Set(float64(atomic.LoadInt64(&unhealthy)))
 }
 
 func (s *DiscoveryServer) addCon(conID string, con *XdsConnection) {
@@ -504,6 +843,10 @@ func (s *DiscoveryServer) addCon(conID string, con *XdsConnection) {
 }
 
 func (s *DiscoveryServer) removeCon(conID string, con *XdsConnection) {
+	if con.cancel != nil {
+		con.cancel()
+	}
+
 	adsClientsMutex.Lock()
 	defer adsClientsMutex.Unlock()
 
@@ -548,6 +891,11 @@ func (s *DiscoveryServer) pushRoute(con *XdsConnection) error {
 	// TODO: once per config update
 	for _, routeName := range con.Routes {
 		// TODO: for ingress/gateway use the other method
+		// BuildSidecarOutboundHTTPRouteConfig has no definition anywhere in this tree (see the
+		// doc comment on buildSidecarOutboundListeners in networking/core/v1alpha3/listener.go);
+		// it's expected to build each RouteAction with buildRouteActionTimeout and
+		// buildRouteActionHashPolicy (networking/core/v1alpha3/route.go), the same way
+		// BuildClusters builds each Cluster with applyLoadBalancer/applyConsistentHashLoadBalancer.
 		r := s.ConfigGenerator.BuildSidecarOutboundHTTPRouteConfig(s.env, *con.modelNode, proxyInstances,
 			services, routeName)
 
@@ -591,13 +939,22 @@ func (con *XdsConnection) send(res *xdsapi.DiscoveryResponse) error {
 		done <- err
 	}()
 	select {
+	case <-con.ctx.Done():
+		_ = t.Stop()
+		return con.ctx.Err()
 	case <-t.C:
 		// TODO: wait for ACK
 		adsLog.Infof("Timeout writing %s", con.ConID)
 		writeTimeout.Add(1)
+		con.recordPushFailure()
 		return errors.New("timeout sending")
 	case err, _ := <-done:
 		_ = t.Stop()
+		if err != nil {
+			con.recordPushFailure()
+		} else {
+			con.consecutiveFailures = 0
+		}
 		return err
 	}
 }
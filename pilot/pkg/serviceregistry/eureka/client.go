@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import "strings"
+
+// metadata is the user-defined metadata blob attached to a Eureka
+// application or instance.
+type metadata map[string]string
+
+// port describes a single Eureka instance port (plain or secure).
+type port struct {
+	Port    int  `json:"$"`
+	Enabled bool `json:"@enabled,string"`
+}
+
+// amazonInfoClass is the dataCenterInfo "@class" value Eureka's AWS
+// DiscoveryClient reports, used to decide whether to read AWS-specific
+// metadata keys (e.g. availability-zone) instead of the generic locality
+// metadata keys.
+const amazonInfoClass = "com.netflix.appinfo.AmazonInfo"
+
+// dataCenterInfo carries Eureka's free-form data center descriptor. AWS
+// instances populate Metadata with EC2 facts (availability-zone, region,
+// instance-id, ...); other data centers typically leave it empty.
+type dataCenterInfo struct {
+	Class    string   `json:"@class"`
+	Name     string   `json:"name"`
+	Metadata metadata `json:"metadata"`
+}
+
+// instance is a single registered Eureka service instance, as returned by
+// the Eureka REST API's /apps endpoint.
+type instance struct {
+	Hostname       string         `json:"hostName"`
+	App            string         `json:"app"`
+	IPAddress      string         `json:"ipAddr"`
+	Status         string         `json:"status"`
+	Port           port           `json:"port"`
+	SecurePort     port           `json:"securePort"`
+	Metadata       metadata       `json:"metadata"`
+	DataCenterInfo dataCenterInfo `json:"dataCenterInfo"`
+}
+
+// application is a named group of Eureka instances.
+type application struct {
+	Name      string      `json:"name"`
+	Instances []*instance `json:"instance"`
+}
+
+// Eureka instance statuses, as reported in the instance's "status" field.
+const (
+	statusUp           = "UP"
+	statusDown         = "DOWN"
+	statusStarting     = "STARTING"
+	statusOutOfService = "OUT_OF_SERVICE"
+)
+
+// Client fetches application/instance data from a Eureka server.
+type Client interface {
+	Applications() ([]*application, error)
+}
+
+// StatusPredicate decides whether an instance in the given Eureka status
+// should be treated as an eligible endpoint. Wired into the Controller
+// construction path so operators can opt into non-UP statuses for warm-up
+// traffic (STARTING) or controlled draining (OUT_OF_SERVICE).
+type StatusPredicate func(status string) bool
+
+// UpOnly is the default StatusPredicate: only instances reporting UP are
+// eligible endpoints.
+func UpOnly(status string) bool {
+	return status == statusUp
+}
+
+// ParseStatusSet builds a StatusPredicate that accepts any of the
+// comma-separated statuses in csv (e.g. "UP,STARTING"), case-insensitively.
+// An empty csv falls back to UpOnly.
+func ParseStatusSet(csv string) StatusPredicate {
+	csv = strings.TrimSpace(csv)
+	if csv == "" {
+		return UpOnly
+	}
+
+	set := make(map[string]bool)
+	for _, status := range strings.Split(csv, ",") {
+		status = strings.ToUpper(strings.TrimSpace(status))
+		if status != "" {
+			set[status] = true
+		}
+	}
+
+	return func(status string) bool {
+		return set[strings.ToUpper(status)]
+	}
+}
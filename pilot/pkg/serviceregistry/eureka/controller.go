@@ -0,0 +1,265 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"fmt"
+	"strings"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// RegistrySource is one of the N Eureka registries a multi-cluster
+// Controller aggregates. Locality (e.g. "region/zone") annotates instances
+// from this source that don't already carry locality metadata (see
+// convertLocality), and is also what PreferLocal compares against the
+// Controller's own locality.
+type RegistrySource struct {
+	Client   Client
+	Locality string
+}
+
+// Controller communicates with one or more Eureka servers and translates
+// their combined application/instance data into Istio's service model,
+// following the same registry-adapter shape as Pilot's other
+// ServiceDiscovery implementations.
+type Controller struct {
+	sources []RegistrySource
+
+	// localityLabels maps Eureka metadata keys to locality fields; see
+	// LocalityLabels for the defaults applied when unset.
+	localityLabels LocalityLabels
+
+	// statusPredicate decides which Eureka instance statuses are accepted as
+	// eligible endpoints; defaults to UpOnly.
+	statusPredicate StatusPredicate
+
+	// preferLocal and localLocality implement nearest-first preference: when
+	// preferLocal is true and a hostname has at least one instance whose
+	// locality equals localLocality, only those local instances are
+	// returned for it; hostnames with no local instances still return
+	// whatever remote instances are available.
+	preferLocal   bool
+	localLocality string
+}
+
+// NewController creates a Controller backed by a single Eureka registry
+// reachable through client.
+func NewController(client Client) *Controller {
+	return NewMultiController([]RegistrySource{{Client: client}})
+}
+
+// NewMultiController creates a Controller that aggregates N Eureka
+// registries, merging their services and instances as if they were one
+// registry.
+func NewMultiController(sources []RegistrySource) *Controller {
+	return &Controller{
+		sources:         sources,
+		localityLabels:  defaultLocalityLabels,
+		statusPredicate: UpOnly,
+	}
+}
+
+// WithLocalityLabels overrides the metadata keys used to derive instance
+// locality, for operators whose Eureka clients populate non-default keys.
+func (c *Controller) WithLocalityLabels(labels LocalityLabels) *Controller {
+	c.localityLabels = labels
+	return c
+}
+
+// WithStatusPredicate overrides which Eureka instance statuses are accepted
+// as eligible endpoints, e.g. ParseStatusSet("UP,STARTING") to also accept
+// warming-up instances.
+func (c *Controller) WithStatusPredicate(predicate StatusPredicate) *Controller {
+	c.statusPredicate = predicate
+	return c
+}
+
+// WithPreferLocal enables nearest-first preference: hostnames with at least
+// one instance whose locality equals localLocality expose only their local
+// instances, falling back to remote instances otherwise.
+func (c *Controller) WithPreferLocal(localLocality string) *Controller {
+	c.preferLocal = true
+	c.localLocality = localLocality
+	return c
+}
+
+// autoDestinationRuleNamespace is the namespace synthesized DestinationRules are written to,
+// separating them from anything an operator authors by hand.
+const autoDestinationRuleNamespace = "istio-eureka-auto"
+
+// SyncAutoDestinationRules writes the current AutoDestinationRules() output into store, creating
+// or updating one config per hostname so it merges into the xDS snapshot alongside
+// user-authored DestinationRules the same way any other config source's writes do. This is the
+// config-controller integration point AutoDestinationRules' synthesis needed to actually take
+// effect; callers re-invoke it on a timer or after a registry refresh to keep store current, the
+// same way env.EnvoyFilterPatches in networking/core/v1alpha3/listener.go resolves a proxy's
+// patches by calling through to model rather than recomputing them here.
+func (c *Controller) SyncAutoDestinationRules(store model.ConfigStore) error {
+	rules, err := c.AutoDestinationRules()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		config := model.Config{
+			ConfigMeta: model.ConfigMeta{
+				Type:      model.DestinationRule.Type,
+				Name:      autoDestinationRuleConfigName(rule.Host),
+				Namespace: autoDestinationRuleNamespace,
+			},
+			Spec: rule,
+		}
+
+		if existing, exists := store.Get(config.Type, config.Name, config.Namespace); exists {
+			config.ResourceVersion = existing.ResourceVersion
+			if _, err := store.Update(config); err != nil {
+				return fmt.Errorf("eureka: updating auto DestinationRule for %s: %v", rule.Host, err)
+			}
+			continue
+		}
+		if _, err := store.Create(config); err != nil {
+			return fmt.Errorf("eureka: creating auto DestinationRule for %s: %v", rule.Host, err)
+		}
+	}
+	return nil
+}
+
+// autoDestinationRuleConfigName derives a config name from host, since a DestinationRule's name
+// must be a valid Kubernetes-style resource name while a Eureka hostname may contain characters
+// (e.g. underscores) that isn't.
+func autoDestinationRuleConfigName(host string) string {
+	return strings.ReplaceAll(strings.ToLower(host), "_", "-")
+}
+
+// allApplications fetches every source's applications, returning them both
+// concatenated (for building the merged service set) and per-source (so
+// instances can be annotated with their source's locality).
+func (c *Controller) allApplications() (merged []*application, perSource [][]*application, err error) {
+	perSource = make([][]*application, len(c.sources))
+	for i, src := range c.sources {
+		apps, err := src.Client.Applications()
+		if err != nil {
+			return nil, nil, err
+		}
+		perSource[i] = apps
+		merged = append(merged, apps...)
+	}
+	return merged, perSource, nil
+}
+
+// Services returns all services known across the aggregated Eureka
+// registries, deduplicated on hostname.
+func (c *Controller) Services() ([]*model.Service, error) {
+	apps, _, err := c.allApplications()
+	if err != nil {
+		return nil, err
+	}
+
+	services := convertServices(apps, nil, c.statusPredicate)
+	out := make([]*model.Service, 0, len(services))
+	for _, service := range services {
+		out = append(out, service)
+	}
+	return out, nil
+}
+
+// Instances returns all service instances known across the aggregated
+// Eureka registries, with PreferLocal applied if configured.
+func (c *Controller) Instances() ([]*model.ServiceInstance, error) {
+	apps, perSource, err := c.allApplications()
+	if err != nil {
+		return nil, err
+	}
+
+	services := convertServices(apps, nil, c.statusPredicate)
+
+	var out []*model.ServiceInstance
+	for i, src := range c.sources {
+		out = append(out, convertServiceInstances(services, perSource[i], c.localityLabels, c.statusPredicate, src.Locality)...)
+	}
+
+	if c.preferLocal {
+		out = preferLocalInstances(out, c.localLocality)
+	}
+	return out, nil
+}
+
+// preferLocalInstances implements nearest-first preference: for any
+// hostname with at least one instance whose locality equals localLocality,
+// only those local instances are kept; hostnames with no local instances
+// are left with whatever remote instances they have.
+func preferLocalInstances(instances []*model.ServiceInstance, localLocality string) []*model.ServiceInstance {
+	if localLocality == "" {
+		return instances
+	}
+
+	hasLocal := make(map[model.Hostname]bool)
+	for _, inst := range instances {
+		if inst.Endpoint.Locality == localLocality {
+			hasLocal[inst.Service.Hostname] = true
+		}
+	}
+
+	out := make([]*model.ServiceInstance, 0, len(instances))
+	for _, inst := range instances {
+		if hasLocal[inst.Service.Hostname] && inst.Endpoint.Locality != localLocality {
+			continue
+		}
+		out = append(out, inst)
+	}
+	return out
+}
+
+// AutoDestinationRules synthesizes one DestinationRule per Eureka-registered
+// hostname that should participate in mesh TLS (see autoTLSMode): either it
+// only enables its securePort, or an operator set the istio.tls metadata
+// override.
+func (c *Controller) AutoDestinationRules() ([]*networking.DestinationRule, error) {
+	apps, _, err := c.allApplications()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[model.Hostname]bool)
+	var out []*networking.DestinationRule
+	for _, app := range apps {
+		for _, inst := range app.Instances {
+			if !c.statusPredicate(inst.Status) {
+				continue
+			}
+
+			hostname := model.Hostname(inst.Hostname)
+			if seen[hostname] {
+				continue
+			}
+
+			mode, ok := autoTLSMode(inst)
+			if !ok {
+				continue
+			}
+
+			seen[hostname] = true
+			out = append(out, &networking.DestinationRule{
+				Host: string(hostname),
+				TrafficPolicy: &networking.TrafficPolicy{
+					Tls: &networking.ClientTLSSettings{Mode: mode},
+				},
+			})
+		}
+	}
+	return out, nil
+}
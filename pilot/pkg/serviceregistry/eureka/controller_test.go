@@ -0,0 +1,172 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"sort"
+	"testing"
+
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// fakeClient is a canned Client backed by a fixed set of applications, so
+// Controller's aggregation logic can be exercised without a real Eureka
+// server.
+type fakeClient struct {
+	apps []*application
+}
+
+func (f *fakeClient) Applications() ([]*application, error) {
+	return f.apps, nil
+}
+
+func reviewsInstance(id, ip string, securePort bool) *instance {
+	inst := &instance{
+		Hostname:  "reviews.default.svc.cluster.local",
+		App:       "REVIEWS",
+		IPAddress: ip,
+		Status:    statusUp,
+	}
+	if securePort {
+		inst.SecurePort = port{Port: 9443, Enabled: true}
+	} else {
+		inst.Port = port{Port: 9080, Enabled: true}
+	}
+	return inst
+}
+
+func TestControllerServicesMergeAcrossClusters(t *testing.T) {
+	east := &fakeClient{apps: []*application{{Name: "REVIEWS", Instances: []*instance{reviewsInstance("1", "10.0.0.1", false)}}}}
+	west := &fakeClient{apps: []*application{{Name: "REVIEWS", Instances: []*instance{reviewsInstance("2", "10.0.1.1", true)}}}}
+
+	controller := NewMultiController([]RegistrySource{
+		{Client: east, Locality: "us-east/us-east-1a"},
+		{Client: west, Locality: "us-west/us-west-1a"},
+	})
+
+	services, err := controller.Services()
+	if err != nil {
+		t.Fatalf("Services() returned error: %v", err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("Services() = %d services, want 1 (merged on hostname)", len(services))
+	}
+
+	ports := services[0].Ports
+	if len(ports) != 2 {
+		t.Fatalf("merged service has %d ports, want 2 (one per cluster)", len(ports))
+	}
+}
+
+func TestControllerInstancesDedupConflictingProtocols(t *testing.T) {
+	// Both clusters expose the same hostname/port with a conflicting protocol
+	// hint; convertServices logs a warning and keeps the first protocol seen
+	// rather than producing two entries for the same port.
+	eastInst := reviewsInstance("1", "10.0.0.1", false)
+	eastInst.Metadata = metadata{protocolMetadata: "http"}
+	westInst := reviewsInstance("2", "10.0.1.1", false)
+	westInst.Metadata = metadata{protocolMetadata: "grpc"}
+
+	east := &fakeClient{apps: []*application{{Name: "REVIEWS", Instances: []*instance{eastInst}}}}
+	west := &fakeClient{apps: []*application{{Name: "REVIEWS", Instances: []*instance{westInst}}}}
+
+	controller := NewMultiController([]RegistrySource{{Client: east}, {Client: west}})
+
+	services, err := controller.Services()
+	if err != nil {
+		t.Fatalf("Services() returned error: %v", err)
+	}
+	if len(services) != 1 || len(services[0].Ports) != 1 {
+		t.Fatalf("Services() = %+v, want a single merged service with one deduplicated port", services)
+	}
+	if services[0].Ports[0].Protocol != model.ProtocolHTTP {
+		t.Errorf("merged port protocol = %v, want %v (first-seen wins)", services[0].Ports[0].Protocol, model.ProtocolHTTP)
+	}
+
+	instances, err := controller.Instances()
+	if err != nil {
+		t.Fatalf("Instances() returned error: %v", err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("Instances() = %d instances, want 2 (one endpoint per cluster, ports not deduped across endpoints)", len(instances))
+	}
+}
+
+func TestControllerPreferLocalFallsBackToRemote(t *testing.T) {
+	east := &fakeClient{apps: []*application{{Name: "REVIEWS", Instances: []*instance{reviewsInstance("1", "10.0.0.1", false)}}}}
+	west := &fakeClient{apps: []*application{{Name: "RATINGS", Instances: []*instance{
+		func() *instance {
+			inst := reviewsInstance("2", "10.0.1.1", false)
+			inst.Hostname = "ratings.default.svc.cluster.local"
+			return inst
+		}(),
+	}}}}
+
+	controller := NewMultiController([]RegistrySource{
+		{Client: east, Locality: "us-east/us-east-1a"},
+		{Client: west, Locality: "us-west/us-west-1a"},
+	}).WithPreferLocal("us-east/us-east-1a")
+
+	instances, err := controller.Instances()
+	if err != nil {
+		t.Fatalf("Instances() returned error: %v", err)
+	}
+
+	var hostnames []string
+	for _, inst := range instances {
+		hostnames = append(hostnames, string(inst.Service.Hostname))
+	}
+	sort.Strings(hostnames)
+
+	want := []string{"ratings.default.svc.cluster.local", "reviews.default.svc.cluster.local"}
+	if len(hostnames) != len(want) {
+		t.Fatalf("Instances() hostnames = %v, want %v", hostnames, want)
+	}
+	for i := range want {
+		if hostnames[i] != want[i] {
+			t.Fatalf("Instances() hostnames = %v, want %v", hostnames, want)
+		}
+	}
+
+	for _, inst := range instances {
+		switch inst.Service.Hostname {
+		case "reviews.default.svc.cluster.local":
+			if inst.Endpoint.Locality != "us-east/us-east-1a" {
+				t.Errorf("reviews instance locality = %q, want local cluster since a local instance exists", inst.Endpoint.Locality)
+			}
+		case "ratings.default.svc.cluster.local":
+			if inst.Endpoint.Locality != "us-west/us-west-1a" {
+				t.Errorf("ratings instance locality = %q, want remote cluster since no local instance exists", inst.Endpoint.Locality)
+			}
+		}
+	}
+}
+
+// TestAutoDestinationRuleConfigName covers the host -> config-name derivation
+// SyncAutoDestinationRules relies on to give each synthesized DestinationRule a valid name,
+// including the underscore case a Eureka hostname can contain but a Kubernetes-style resource
+// name can't.
+func TestAutoDestinationRuleConfigName(t *testing.T) {
+	cases := map[string]string{
+		"reviews.default.svc.cluster.local":    "reviews.default.svc.cluster.local",
+		"RATINGS.default.svc.cluster.local":    "ratings.default.svc.cluster.local",
+		"legacy_app.default.svc.cluster.local": "legacy-app.default.svc.cluster.local",
+	}
+	for host, want := range cases {
+		if got := autoDestinationRuleConfigName(host); got != want {
+			t.Errorf("autoDestinationRuleConfigName(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
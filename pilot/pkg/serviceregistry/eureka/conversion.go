@@ -16,14 +16,17 @@ package eureka
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
+	networking "istio.io/api/networking/v1alpha3"
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/log"
 )
 
 // Convert Eureka applications to services. If provided, only convert applications in the hostnames whitelist,
 // otherwise convert all.
-func convertServices(apps []*application, hostnames map[model.Hostname]bool) map[model.Hostname]*model.Service {
+func convertServices(apps []*application, hostnames map[model.Hostname]bool, accept StatusPredicate) map[model.Hostname]*model.Service {
 	services := make(map[model.Hostname]*model.Service)
 	for _, app := range apps {
 		for _, instance := range app.Instances {
@@ -32,7 +35,7 @@ func convertServices(apps []*application, hostnames map[model.Hostname]bool) map
 				continue
 			}
 
-			if instance.Status != statusUp {
+			if !accept(instance.Status) {
 				continue
 			}
 
@@ -54,15 +57,14 @@ func convertServices(apps []*application, hostnames map[model.Hostname]bool) map
 				services[hostname] = service
 			}
 
-			protocol := convertProtocol(instance.Metadata)
 			for _, port := range ports {
 				if servicePort, exists := service.Ports.GetByPort(port.Port); exists {
-					if servicePort.Protocol != protocol {
+					if servicePort.Protocol != port.Protocol {
 						log.Warnf(
 							"invalid Eureka config: "+
 								"%s:%d has conflicting protocol definitions %s, %s",
 							instance.Hostname, servicePort.Port,
-							servicePort.Protocol, protocol)
+							servicePort.Protocol, port.Protocol)
 					}
 					continue
 				}
@@ -76,7 +78,8 @@ func convertServices(apps []*application, hostnames map[model.Hostname]bool) map
 
 // Convert Eureka applications to service instances. The services argument must contain a map of hostnames to
 // services. Only service instances with a corresponding service are converted.
-func convertServiceInstances(services map[model.Hostname]*model.Service, apps []*application) []*model.ServiceInstance {
+func convertServiceInstances(services map[model.Hostname]*model.Service, apps []*application, localityLabels LocalityLabels,
+	accept StatusPredicate, fallbackLocality string) []*model.ServiceInstance {
 	out := make([]*model.ServiceInstance, 0)
 	for _, app := range apps {
 		for _, instance := range app.Instances {
@@ -85,19 +88,29 @@ func convertServiceInstances(services map[model.Hostname]*model.Service, apps []
 				continue
 			}
 
-			if instance.Status != statusUp {
+			if !accept(instance.Status) {
 				continue
 			}
 
+			locality, topologyLabels := convertLocality(instance, localityLabels)
+			if locality == "" {
+				locality = fallbackLocality
+			}
+			labels := convertLabels(instance.Metadata)
+			for k, v := range topologyLabels {
+				labels[k] = v
+			}
+
 			for _, port := range convertPorts(instance) {
 				out = append(out, &model.ServiceInstance{
 					Endpoint: model.NetworkEndpoint{
 						Address:     instance.IPAddress,
 						Port:        port.Port,
 						ServicePort: port,
+						Locality:    locality,
 					},
 					Service: services[hostname],
-					Labels:  convertLabels(instance.Metadata),
+					Labels:  labels,
 				})
 			}
 		}
@@ -105,29 +118,173 @@ func convertServiceInstances(services map[model.Hostname]*model.Service, apps []
 	return out
 }
 
+// LocalityLabels configures which Eureka instance metadata keys map to
+// Istio locality (region/zone/sub-zone), so Pilot can emit locality-weighted
+// EDS clusters for Eureka-backed services. Wired from the Eureka
+// Controller's configuration so operators can point it at whatever keys
+// their registrar populates.
+type LocalityLabels struct {
+	Region  string
+	Zone    string
+	SubZone string
+}
+
+// defaultLocalityLabels matches the metadata keys most Eureka clients (and
+// Istio's own Eureka publisher) populate out of the box.
+var defaultLocalityLabels = LocalityLabels{
+	Region:  "region",
+	Zone:    "zone",
+	SubZone: "sub_zone",
+}
+
+const (
+	// topologyRegionLabel, topologyZoneLabel and topologySubZoneLabel are the
+	// canonical Istio topology labels Pilot's locality load balancing reads.
+	topologyRegionLabel  = "topology.istio.io/region"
+	topologyZoneLabel    = "topology.istio.io/zone"
+	topologySubZoneLabel = "topology.istio.io/subzone"
+
+	// awsAvailabilityZoneMetadata is the dataCenterInfo metadata key AWS's
+	// AmazonInfo populates with the instance's availability zone.
+	awsAvailabilityZoneMetadata = "availability-zone"
+)
+
+// convertLocality derives an Istio locality string ("region/zone/subzone")
+// and the corresponding topology.istio.io/* labels from instance metadata,
+// preferring AWS dataCenterInfo fields when the instance reports an
+// AmazonInfo data center and no explicit locality metadata is set.
+func convertLocality(instance *instance, labels LocalityLabels) (string, model.Labels) {
+	region, zone, subZone := instance.Metadata[labels.Region], instance.Metadata[labels.Zone], instance.Metadata[labels.SubZone]
+
+	if instance.DataCenterInfo.Class == amazonInfoClass {
+		if az, ok := instance.DataCenterInfo.Metadata[awsAvailabilityZoneMetadata]; ok {
+			if zone == "" {
+				zone = az
+			}
+			if region == "" {
+				region = awsRegionFromZone(az)
+			}
+		}
+	}
+
+	if region == "" && zone == "" && subZone == "" {
+		return "", nil
+	}
+
+	topology := make(model.Labels)
+	if region != "" {
+		topology[topologyRegionLabel] = region
+	}
+	if zone != "" {
+		topology[topologyZoneLabel] = zone
+	}
+	if subZone != "" {
+		topology[topologySubZoneLabel] = subZone
+	}
+
+	return fmt.Sprintf("%s/%s/%s", region, zone, subZone), topology
+}
+
+// awsRegionFromZone derives an AWS region from an availability zone name
+// (e.g. "us-east-1a" -> "us-east-1"), matching the convention AWS itself
+// uses, for instances that report an availability zone but no explicit
+// region metadata.
+func awsRegionFromZone(az string) string {
+	if az == "" {
+		return ""
+	}
+	last := az[len(az)-1]
+	if last >= 'a' && last <= 'z' {
+		return az[:len(az)-1]
+	}
+	return az
+}
+
 func convertPorts(instance *instance) model.PortList {
 	out := make(model.PortList, 0, 2) // Eureka instances have 0..2 enabled ports
-	protocol := convertProtocol(instance.Metadata)
-	for _, port := range []port{instance.Port, instance.SecurePort} {
-		if !port.Enabled {
+	ports := []struct {
+		port   port
+		secure bool
+	}{
+		{instance.Port, false},
+		{instance.SecurePort, true},
+	}
+	for _, p := range ports {
+		if !p.port.Enabled {
 			continue
 		}
 
+		protocol := convertProtocol(instance.Metadata, p.port.Port)
+		if p.secure {
+			protocol = secureProtocol(protocol)
+		}
 		out = append(out, &model.Port{
-			Name:     fmt.Sprint(port.Port),
-			Port:     port.Port,
+			Name:     portName(protocol, p.port.Port),
+			Port:     p.port.Port,
 			Protocol: protocol,
 		})
 	}
 	return out
 }
 
+// secureProtocol upgrades an insecure protocol to the protocol its
+// securePort.enabled=true counterpart carries once Envoy terminates TLS:
+// HTTP becomes HTTPS, gRPC keeps running over HTTP2, and anything else is
+// treated as opaque TLS passthrough.
+func secureProtocol(protocol model.Protocol) model.Protocol {
+	switch protocol {
+	case model.ProtocolHTTP:
+		return model.ProtocolHTTPS
+	case model.ProtocolGRPC:
+		return model.ProtocolHTTP2
+	default:
+		return model.ProtocolTLS
+	}
+}
+
+// portName builds a Kubernetes-style named port (e.g. "http-8080") from the
+// resolved protocol so that downstream Pilot code that keys off
+// model.Port.Name has something human-readable, rather than the bare port
+// number Eureka instances carry on the wire.
+func portName(protocol model.Protocol, port int) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(string(protocol)), port)
+}
+
 const protocolMetadata = "istio.protocol" // metadata key for port protocol
 
-func convertProtocol(md metadata) model.Protocol {
-	name := md[protocolMetadata]
+// portNameMetadata is an optional metadata key operators can set to a
+// Kubernetes-style named port (e.g. "grpc-web-9090"); when istio.protocol is
+// absent it is used as a protocol hint the same way Service port names are.
+const portNameMetadata = "port.name"
+
+// protocolNamePrefixes maps the leading '-'-delimited token of a port name
+// to the protocol it conventionally designates, matching the rules the
+// Kubernetes adapter applies to Service port names.
+var protocolNamePrefixes = map[string]model.Protocol{
+	"http":  model.ProtocolHTTP,
+	"http2": model.ProtocolHTTP2,
+	"grpc":  model.ProtocolGRPC,
+	"https": model.ProtocolHTTPS,
+	"tls":   model.ProtocolTLS,
+	"mongo": model.ProtocolMongo,
+	"redis": model.ProtocolRedis,
+	"mysql": model.ProtocolMySQL,
+	"tcp":   model.ProtocolTCP,
+	"udp":   model.ProtocolUDP,
+}
 
-	if md != nil {
+// wellKnownPorts is a last-resort fallback, applied when neither
+// istio.protocol nor a named port is available, for ports whose protocol is
+// widely standardized.
+var wellKnownPorts = map[int]model.Protocol{
+	25:    model.ProtocolTCP,   // SMTP
+	53:    model.ProtocolTCP,   // DNS
+	3306:  model.ProtocolMySQL, // MySQL
+	27017: model.ProtocolMongo, // MongoDB
+}
+
+func convertProtocol(md metadata, port int) model.Protocol {
+	if name, ok := md[protocolMetadata]; ok {
 		protocol := model.ParseProtocol(name)
 		if protocol == model.ProtocolUnsupported {
 			log.Warnf("unsupported protocol value: %s", name)
@@ -135,18 +292,116 @@ func convertProtocol(md metadata) model.Protocol {
 			return protocol
 		}
 	}
+
+	if name, ok := md[portNameMetadata]; ok {
+		if protocol, ok := protocolFromName(name); ok {
+			return protocol
+		}
+	}
+
+	if protocol, ok := wellKnownPorts[port]; ok {
+		return protocol
+	}
+
 	return model.ProtocolTCP // default protocol
 }
 
-func convertLabels(metadata metadata) model.Labels {
+// protocolFromName applies the Kubernetes Service port naming convention to
+// a Eureka port name hint: the leading '-'-delimited token is the protocol,
+// e.g. "http-8080" -> HTTP. "grpc-web" is checked whole first since it is
+// itself a hyphenated protocol name.
+func protocolFromName(name string) (model.Protocol, bool) {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "grpc-web") {
+		return model.ProtocolGRPCWeb, true
+	}
+
+	prefix := lower
+	if i := strings.Index(lower, "-"); i >= 0 {
+		prefix = lower[:i]
+	}
+	protocol, ok := protocolNamePrefixes[prefix]
+	return protocol, ok
+}
+
+const (
+	weightMetadata  = "istio.weight"  // metadata key for DestinationRule subset weight
+	subsetMetadata  = "istio.subset"  // metadata key for DestinationRule subset name
+	versionMetadata = "istio.version" // metadata key for the canonical "version" label
+
+	versionLabel = "version"         // canonical Istio label subsets commonly key on
+	weightLabel  = "istio.io/weight" // canonical Istio label for subset/LB weighting
+	subsetLabel  = "istio.io/subset" // canonical Istio label naming a DestinationRule subset
+)
+
+// reservedMetadata is the set of Eureka metadata keys the adapter consumes
+// itself rather than surfacing as plain labels. Centralized here so that
+// future reserved keys only need to be added in one place.
+var reservedMetadata = map[string]bool{
+	protocolMetadata: true,
+	portNameMetadata: true,
+	"@class":         true,
+	weightMetadata:   true,
+	subsetMetadata:   true,
+	versionMetadata:  true,
+	tlsMetadata:      true,
+}
+
+func convertLabels(md metadata) model.Labels {
 	labels := make(model.Labels)
-	for k, v := range metadata {
+	for k, v := range md {
+		if reservedMetadata[k] {
+			continue
+		}
 		labels[k] = v
 	}
 
-	// filter out special labels
-	delete(labels, protocolMetadata)
-	delete(labels, "@class")
+	if version, ok := md[versionMetadata]; ok {
+		labels[versionLabel] = version
+	}
+	if subset, ok := md[subsetMetadata]; ok {
+		labels[subsetLabel] = subset
+	}
+	if weight, ok := md[weightMetadata]; ok {
+		if w, err := strconv.Atoi(weight); err != nil || w < 0 {
+			log.Warnf("invalid Eureka config: %s=%q must be a non-negative integer, ignoring", weightMetadata, weight)
+		} else {
+			labels[weightLabel] = weight
+		}
+	}
 
 	return labels
 }
+
+// tlsMetadata is the metadata key operators can set to override the
+// inferred TLS mode, to one of "simple", "mutual", or "istio_mutual".
+const tlsMetadata = "istio.tls"
+
+// tlsModeValues maps the istio.tls metadata values operators may set to the
+// corresponding networking.ClientTLSSettings mode.
+var tlsModeValues = map[string]networking.ClientTLSSettings_TLSmode{
+	"simple":       networking.ClientTLSSettings_SIMPLE,
+	"mutual":       networking.ClientTLSSettings_MUTUAL,
+	"istio_mutual": networking.ClientTLSSettings_ISTIO_MUTUAL,
+}
+
+// autoTLSMode decides whether instance should get a synthetic
+// DestinationRule enabling TLS, and with which mode: an explicit
+// istio.tls metadata override always wins; otherwise an instance that only
+// enables its secure port is assumed to want mesh mTLS, so Eureka-registered
+// services participate in it without operators writing a DestinationRule by
+// hand.
+func autoTLSMode(instance *instance) (networking.ClientTLSSettings_TLSmode, bool) {
+	if value, ok := instance.Metadata[tlsMetadata]; ok {
+		if mode, ok := tlsModeValues[strings.ToLower(value)]; ok {
+			return mode, true
+		}
+		log.Warnf("invalid Eureka config: unsupported %s value %q", tlsMetadata, value)
+	}
+
+	if instance.SecurePort.Enabled && !instance.Port.Enabled {
+		return networking.ClientTLSSettings_ISTIO_MUTUAL, true
+	}
+
+	return networking.ClientTLSSettings_TLSmode(0), false
+}
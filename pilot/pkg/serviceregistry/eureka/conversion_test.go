@@ -0,0 +1,382 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"testing"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+func TestConvertProtocol(t *testing.T) {
+	tests := []struct {
+		name string
+		md   metadata
+		port int
+		want model.Protocol
+	}{
+		{
+			name: "istio.protocol metadata wins over everything else",
+			md:   metadata{protocolMetadata: "grpc", portNameMetadata: "http-8080"},
+			port: 3306,
+			want: model.ProtocolGRPC,
+		},
+		{
+			name: "invalid istio.protocol metadata falls through to port name",
+			md:   metadata{protocolMetadata: "bogus", portNameMetadata: "https-8443"},
+			port: 8443,
+			want: model.ProtocolHTTPS,
+		},
+		{
+			name: "port name prefix before first hyphen",
+			md:   metadata{portNameMetadata: "http-8080"},
+			port: 8080,
+			want: model.ProtocolHTTP,
+		},
+		{
+			name: "grpc-web is matched whole, not split on its hyphen",
+			md:   metadata{portNameMetadata: "grpc-web"},
+			port: 9090,
+			want: model.ProtocolGRPCWeb,
+		},
+		{
+			name: "grpc-web with a trailing port suffix still matches whole",
+			md:   metadata{portNameMetadata: "grpc-web-9090"},
+			port: 9090,
+			want: model.ProtocolGRPCWeb,
+		},
+		{
+			name: "unrecognized port name falls through to well-known ports",
+			md:   metadata{portNameMetadata: "whatever"},
+			port: 3306,
+			want: model.ProtocolMySQL,
+		},
+		{
+			name: "well-known port with no metadata at all",
+			md:   metadata{},
+			port: 27017,
+			want: model.ProtocolMongo,
+		},
+		{
+			name: "unrecognized port with no metadata defaults to TCP",
+			md:   metadata{},
+			port: 12345,
+			want: model.ProtocolTCP,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertProtocol(tt.md, tt.port); got != tt.want {
+				t.Errorf("convertProtocol(%v, %d) = %v, want %v", tt.md, tt.port, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertLocality(t *testing.T) {
+	tests := []struct {
+		name         string
+		inst         *instance
+		wantLocality string
+		wantTopology model.Labels
+	}{
+		{
+			name:         "explicit region/zone/sub_zone metadata",
+			inst:         &instance{Metadata: metadata{"region": "us-east", "zone": "us-east-1a", "sub_zone": "rack1"}},
+			wantLocality: "us-east/us-east-1a/rack1",
+			wantTopology: model.Labels{
+				topologyRegionLabel:  "us-east",
+				topologyZoneLabel:    "us-east-1a",
+				topologySubZoneLabel: "rack1",
+			},
+		},
+		{
+			name: "AWS AmazonInfo availability-zone fills in zone and region",
+			inst: &instance{
+				DataCenterInfo: dataCenterInfo{
+					Class:    amazonInfoClass,
+					Metadata: metadata{awsAvailabilityZoneMetadata: "us-west-2a"},
+				},
+			},
+			wantLocality: "us-west-2/us-west-2a/",
+			wantTopology: model.Labels{
+				topologyRegionLabel: "us-west-2",
+				topologyZoneLabel:   "us-west-2a",
+			},
+		},
+		{
+			name: "explicit region metadata wins over AWS zone-derived region",
+			inst: &instance{
+				Metadata: metadata{"region": "custom-region"},
+				DataCenterInfo: dataCenterInfo{
+					Class:    amazonInfoClass,
+					Metadata: metadata{awsAvailabilityZoneMetadata: "us-west-2a"},
+				},
+			},
+			wantLocality: "custom-region/us-west-2a/",
+			wantTopology: model.Labels{
+				topologyRegionLabel: "custom-region",
+				topologyZoneLabel:   "us-west-2a",
+			},
+		},
+		{
+			name:         "no locality metadata at all falls back cleanly",
+			inst:         &instance{Metadata: metadata{}},
+			wantLocality: "",
+			wantTopology: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLocality, gotTopology := convertLocality(tt.inst, defaultLocalityLabels)
+			if gotLocality != tt.wantLocality {
+				t.Errorf("convertLocality() locality = %q, want %q", gotLocality, tt.wantLocality)
+			}
+			if len(gotTopology) != len(tt.wantTopology) {
+				t.Fatalf("convertLocality() topology = %v, want %v", gotTopology, tt.wantTopology)
+			}
+			for k, v := range tt.wantTopology {
+				if gotTopology[k] != v {
+					t.Errorf("convertLocality() topology[%q] = %q, want %q", k, gotTopology[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		md   metadata
+		want model.Labels
+	}{
+		{
+			name: "reserved keys are stripped and promoted to canonical labels",
+			md: metadata{
+				versionMetadata:  "v1",
+				subsetMetadata:   "canary",
+				weightMetadata:   "25",
+				protocolMetadata: "http",
+				"@class":         amazonInfoClass,
+				"team":           "reviews",
+			},
+			want: model.Labels{
+				versionLabel: "v1",
+				subsetLabel:  "canary",
+				weightLabel:  "25",
+				"team":       "reviews",
+			},
+		},
+		{
+			name: "negative weight is ignored with a warning, not promoted",
+			md:   metadata{weightMetadata: "-1"},
+			want: model.Labels{},
+		},
+		{
+			name: "non-numeric weight is ignored with a warning, not promoted",
+			md:   metadata{weightMetadata: "not-a-number"},
+			want: model.Labels{},
+		},
+		{
+			name: "plain user metadata passes through untouched",
+			md:   metadata{"team": "ratings", "owner": "alice"},
+			want: model.Labels{"team": "ratings", "owner": "alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertLabels(tt.md)
+			if len(got) != len(tt.want) {
+				t.Fatalf("convertLabels(%v) = %v, want %v", tt.md, got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("convertLabels(%v)[%q] = %q, want %q", tt.md, k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertPorts(t *testing.T) {
+	tests := []struct {
+		name string
+		inst *instance
+		want model.PortList
+	}{
+		{
+			name: "only insecure port enabled",
+			inst: &instance{Port: port{Port: 8080, Enabled: true}},
+			want: model.PortList{{Name: "http-8080", Port: 8080, Protocol: model.ProtocolHTTP}},
+		},
+		{
+			name: "only secure port enabled upgrades the protocol",
+			inst: &instance{SecurePort: port{Port: 8443, Enabled: true}},
+			want: model.PortList{{Name: "https-8443", Port: 8443, Protocol: model.ProtocolHTTPS}},
+		},
+		{
+			name: "both ports enabled with mixed protocols",
+			inst: &instance{
+				Port:       port{Port: 9090, Enabled: true},
+				SecurePort: port{Port: 9091, Enabled: true},
+				Metadata:   metadata{protocolMetadata: "grpc"},
+			},
+			want: model.PortList{
+				{Name: "grpc-9090", Port: 9090, Protocol: model.ProtocolGRPC},
+				{Name: "http2-9091", Port: 9091, Protocol: model.ProtocolHTTP2},
+			},
+		},
+		{
+			name: "neither port enabled yields no ports",
+			inst: &instance{},
+			want: model.PortList{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convertPorts(tt.inst)
+			if len(got) != len(tt.want) {
+				t.Fatalf("convertPorts() = %v, want %v", got, tt.want)
+			}
+			for i, p := range tt.want {
+				if got[i].Name != p.Name || got[i].Port != p.Port || got[i].Protocol != p.Protocol {
+					t.Errorf("convertPorts()[%d] = %+v, want %+v", i, got[i], p)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoTLSMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		inst     *instance
+		wantMode networking.ClientTLSSettings_TLSmode
+		wantOk   bool
+	}{
+		{
+			name:     "explicit istio.tls override wins",
+			inst:     &instance{Metadata: metadata{tlsMetadata: "mutual"}, Port: port{Port: 8080, Enabled: true}, SecurePort: port{Port: 8443, Enabled: true}},
+			wantMode: networking.ClientTLSSettings_MUTUAL,
+			wantOk:   true,
+		},
+		{
+			name:     "invalid istio.tls override falls through to port inference",
+			inst:     &instance{Metadata: metadata{tlsMetadata: "bogus"}, SecurePort: port{Port: 8443, Enabled: true}},
+			wantMode: networking.ClientTLSSettings_ISTIO_MUTUAL,
+			wantOk:   true,
+		},
+		{
+			name:     "secure-only instance is assumed mesh mTLS",
+			inst:     &instance{SecurePort: port{Port: 8443, Enabled: true}},
+			wantMode: networking.ClientTLSSettings_ISTIO_MUTUAL,
+			wantOk:   true,
+		},
+		{
+			name:   "insecure-only instance gets no auto TLS",
+			inst:   &instance{Port: port{Port: 8080, Enabled: true}},
+			wantOk: false,
+		},
+		{
+			name:   "both ports enabled gets no auto TLS",
+			inst:   &instance{Port: port{Port: 8080, Enabled: true}, SecurePort: port{Port: 8443, Enabled: true}},
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMode, gotOk := autoTLSMode(tt.inst)
+			if gotOk != tt.wantOk {
+				t.Fatalf("autoTLSMode() ok = %v, want %v", gotOk, tt.wantOk)
+			}
+			if gotOk && gotMode != tt.wantMode {
+				t.Errorf("autoTLSMode() mode = %v, want %v", gotMode, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestParseStatusSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		csv    string
+		status string
+		want   bool
+	}{
+		{name: "empty csv falls back to UP only", csv: "", status: statusUp, want: true},
+		{name: "empty csv rejects STARTING", csv: "", status: statusStarting, want: false},
+		{name: "UP,STARTING accepts UP", csv: "UP,STARTING", status: statusUp, want: true},
+		{name: "UP,STARTING accepts STARTING", csv: "UP,STARTING", status: statusStarting, want: true},
+		{name: "UP,STARTING rejects OUT_OF_SERVICE", csv: "UP,STARTING", status: statusOutOfService, want: false},
+		{name: "UP,OUT_OF_SERVICE accepts OUT_OF_SERVICE", csv: "UP,OUT_OF_SERVICE", status: statusOutOfService, want: true},
+		{name: "case-insensitive csv and status", csv: "up, starting", status: "Starting", want: true},
+		{name: "unknown status is rejected even with a permissive set", csv: "UP,STARTING", status: "BOGUS", want: false},
+		{name: "whitespace-only csv falls back to UP only", csv: "   ", status: statusUp, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predicate := ParseStatusSet(tt.csv)
+			if got := predicate(tt.status); got != tt.want {
+				t.Errorf("ParseStatusSet(%q)(%q) = %v, want %v", tt.csv, tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpOnly(t *testing.T) {
+	if !UpOnly(statusUp) {
+		t.Error("UpOnly(UP) = false, want true")
+	}
+	for _, status := range []string{statusDown, statusStarting, statusOutOfService, "BOGUS"} {
+		if UpOnly(status) {
+			t.Errorf("UpOnly(%q) = true, want false", status)
+		}
+	}
+}
+
+func TestProtocolFromName(t *testing.T) {
+	tests := []struct {
+		name     string
+		portName string
+		want     model.Protocol
+		wantOk   bool
+	}{
+		{name: "plain http prefix", portName: "http-8080", want: model.ProtocolHTTP, wantOk: true},
+		{name: "grpc-web matched whole", portName: "grpc-web", want: model.ProtocolGRPCWeb, wantOk: true},
+		{name: "grpc-web with suffix matched whole, not split to grpc", portName: "grpc-web-9090", want: model.ProtocolGRPCWeb, wantOk: true},
+		{name: "grpc without web suffix", portName: "grpc", want: model.ProtocolGRPC, wantOk: true},
+		{name: "case insensitive", portName: "HTTP2-9090", want: model.ProtocolHTTP2, wantOk: true},
+		{name: "unknown prefix", portName: "carrier-pigeon-25", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := protocolFromName(tt.portName)
+			if ok != tt.wantOk {
+				t.Fatalf("protocolFromName(%q) ok = %v, want %v", tt.portName, ok, tt.wantOk)
+			}
+			if ok && got != tt.want {
+				t.Errorf("protocolFromName(%q) = %v, want %v", tt.portName, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/log"
+)
+
+// renewalInterval is the interval at which Eureka expects a PUT renewal for
+// a registered instance; Eureka evicts an instance if it misses enough of
+// these in a row (default 90s, configured server-side).
+const renewalInterval = 30 * time.Second
+
+var (
+	publishSuccessTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "eureka_publish_success_total",
+		Help: "Number of successful Eureka registrations/heartbeats/deregistrations.",
+	})
+	publishFailureTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "eureka_publish_failure_total",
+		Help: "Number of failed Eureka registrations/heartbeats/deregistrations.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(publishSuccessTotal)
+	prometheus.MustRegister(publishFailureTotal)
+}
+
+// PublishedWorkload is the subset of a WorkloadEntry (or an annotated
+// Kubernetes Service endpoint) the Publisher needs to register into Eureka.
+// Callers translate their own watch events into this shape so the Publisher
+// itself stays agnostic of the config store it was sourced from.
+type PublishedWorkload struct {
+	App        string
+	InstanceID string
+	Hostname   string
+	Address    string
+	Port       int
+	Labels     model.Labels
+}
+
+// Publisher mirrors Pilot-known workloads into a Eureka server, so non-mesh
+// Eureka clients can discover mesh workloads. It is the write-side
+// counterpart to Controller, which only reads from Eureka.
+type Publisher struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	tracked  map[string]PublishedWorkload // keyed by App+"/"+InstanceID
+	cancelFn map[string]context.CancelFunc
+}
+
+// NewPublisher creates a Publisher that registers instances against the
+// Eureka server at baseURL (e.g. "http://eureka:8761/eureka").
+func NewPublisher(baseURL string) *Publisher {
+	return &Publisher{
+		baseURL:    baseURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		tracked:    make(map[string]PublishedWorkload),
+		cancelFn:   make(map[string]context.CancelFunc),
+	}
+}
+
+// WatchWorkloadEntries subscribes to WorkloadEntry config events on store and keeps each entry's
+// Eureka registration in sync with it: an add/update event publishes the workload (Publish
+// replaces any previous registration for the same App/InstanceID), a delete event unpublishes it.
+// This is the config-controller integration point that makes Publisher a driven sync subsystem
+// rather than a library waiting for a caller, wired the same way env.EnvoyFilterPatches resolves
+// a proxy's EnvoyFilter patches in networking/core/v1alpha3/listener.go: by calling through to a
+// model.ConfigStore method, not reimplementing the watch here.
+func (p *Publisher) WatchWorkloadEntries(ctx context.Context, store model.ConfigStore) {
+	store.RegisterEventHandler(model.WorkloadEntry.Type, func(config model.Config, event model.Event) {
+		workload, ok := workloadFromConfig(config)
+		if !ok {
+			return
+		}
+
+		switch event {
+		case model.EventAdd, model.EventUpdate:
+			if err := p.Publish(ctx, workload); err != nil {
+				log.Warnf("eureka publish: %s %s: %v", event, workload.InstanceID, err)
+			}
+		case model.EventDelete:
+			if err := p.Unpublish(workload); err != nil {
+				log.Warnf("eureka publish: %s %s: %v", event, workload.InstanceID, err)
+			}
+		}
+	})
+}
+
+// workloadFromConfig translates a WorkloadEntry config object into the PublishedWorkload shape
+// Publish/Unpublish expect. ok is false if config isn't a *networking.WorkloadEntry or declares
+// no ports to register.
+func workloadFromConfig(config model.Config) (workload PublishedWorkload, ok bool) {
+	entry, ok := config.Spec.(*networking.WorkloadEntry)
+	if !ok || len(entry.Ports) == 0 {
+		return PublishedWorkload{}, false
+	}
+
+	var port int
+	for _, p := range entry.Ports {
+		port = int(p)
+		break
+	}
+
+	return PublishedWorkload{
+		App:        config.Name,
+		InstanceID: workloadKey(config.Namespace, config.Name),
+		Hostname:   entry.Address,
+		Address:    entry.Address,
+		Port:       port,
+		Labels:     model.Labels(entry.Labels),
+	}, true
+}
+
+// Publish registers workload with Eureka and starts a background goroutine
+// that renews it every renewalInterval until ctx is cancelled or Unpublish
+// is called. Calling Publish again for the same App/InstanceID replaces the
+// previous registration and heartbeat loop.
+func (p *Publisher) Publish(ctx context.Context, workload PublishedWorkload) error {
+	key := workloadKey(workload.App, workload.InstanceID)
+
+	if err := p.register(workload); err != nil {
+		publishFailureTotal.Inc()
+		return fmt.Errorf("eureka publish: registering %s: %v", key, err)
+	}
+	publishSuccessTotal.Inc()
+
+	p.mu.Lock()
+	if cancel, ok := p.cancelFn[key]; ok {
+		cancel()
+	}
+	heartbeatCtx, cancel := context.WithCancel(ctx)
+	p.tracked[key] = workload
+	p.cancelFn[key] = cancel
+	p.mu.Unlock()
+
+	go p.heartbeatLoop(heartbeatCtx, workload)
+	return nil
+}
+
+// Unpublish stops renewing workload and deregisters it from Eureka.
+func (p *Publisher) Unpublish(workload PublishedWorkload) error {
+	key := workloadKey(workload.App, workload.InstanceID)
+
+	p.mu.Lock()
+	if cancel, ok := p.cancelFn[key]; ok {
+		cancel()
+	}
+	delete(p.cancelFn, key)
+	delete(p.tracked, key)
+	p.mu.Unlock()
+
+	if err := p.deregister(workload); err != nil {
+		publishFailureTotal.Inc()
+		return fmt.Errorf("eureka publish: deregistering %s: %v", key, err)
+	}
+	publishSuccessTotal.Inc()
+	return nil
+}
+
+// Close stops all heartbeat loops and deregisters every tracked workload.
+// It collects, rather than stops at, individual deregistration failures so
+// a shutdown always attempts to clean up as much of the registry as it can.
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	workloads := make([]PublishedWorkload, 0, len(p.tracked))
+	for _, w := range p.tracked {
+		workloads = append(workloads, w)
+	}
+	for _, cancel := range p.cancelFn {
+		cancel()
+	}
+	p.tracked = make(map[string]PublishedWorkload)
+	p.cancelFn = make(map[string]context.CancelFunc)
+	p.mu.Unlock()
+
+	var firstErr error
+	for _, w := range workloads {
+		if err := p.deregister(w); err != nil {
+			publishFailureTotal.Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		publishSuccessTotal.Inc()
+	}
+	return firstErr
+}
+
+func (p *Publisher) heartbeatLoop(ctx context.Context, workload PublishedWorkload) {
+	ticker := time.NewTicker(renewalInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.renew(workload); err != nil {
+				publishFailureTotal.Inc()
+				log.Warnf("eureka publish: renewing %s: %v", workloadKey(workload.App, workload.InstanceID), err)
+				continue
+			}
+			publishSuccessTotal.Inc()
+		}
+	}
+}
+
+// register issues Eureka's POST /apps/{APP} registration call.
+func (p *Publisher) register(workload PublishedWorkload) error {
+	body, err := json.Marshal(map[string]interface{}{"instance": toEurekaInstance(workload)})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/apps/%s", p.baseURL, workload.App)
+	return p.do(http.MethodPost, url, bytes.NewReader(body))
+}
+
+// renew issues Eureka's PUT /apps/{APP}/{INSTANCE} heartbeat call.
+func (p *Publisher) renew(workload PublishedWorkload) error {
+	url := fmt.Sprintf("%s/apps/%s/%s", p.baseURL, workload.App, workload.InstanceID)
+	return p.do(http.MethodPut, url, nil)
+}
+
+// deregister issues Eureka's DELETE /apps/{APP}/{INSTANCE} call.
+func (p *Publisher) deregister(workload PublishedWorkload) error {
+	url := fmt.Sprintf("%s/apps/%s/%s", p.baseURL, workload.App, workload.InstanceID)
+	return p.do(http.MethodDelete, url, nil)
+}
+
+func (p *Publisher) do(method, url string, body *bytes.Reader) error {
+	var req *http.Request
+	var err error
+	if body != nil {
+		req, err = http.NewRequest(method, url, body)
+	} else {
+		req, err = http.NewRequest(method, url, nil)
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("eureka server returned %s for %s %s", resp.Status, method, url)
+	}
+	return nil
+}
+
+func workloadKey(app, instanceID string) string {
+	return app + "/" + instanceID
+}
+
+// toEurekaInstance translates a PublishedWorkload back into the wire shape
+// Eureka's registration API expects, round-tripping the istio.protocol,
+// istio.weight and locality metadata keys the adapter promotes to labels on
+// the read side (see convertProtocol, convertLabels, convertLocality), so a
+// workload that round-trips through Eureka and back keeps its settings.
+func toEurekaInstance(workload PublishedWorkload) map[string]interface{} {
+	md := metadata{}
+	for k, v := range workload.Labels {
+		switch k {
+		case versionLabel:
+			md[versionMetadata] = v
+		case weightLabel:
+			md[weightMetadata] = v
+		case subsetLabel:
+			md[subsetMetadata] = v
+		case topologyRegionLabel:
+			md[defaultLocalityLabels.Region] = v
+		case topologyZoneLabel:
+			md[defaultLocalityLabels.Zone] = v
+		case topologySubZoneLabel:
+			md[defaultLocalityLabels.SubZone] = v
+		default:
+			md[k] = v
+		}
+	}
+
+	return map[string]interface{}{
+		"hostName":   workload.Hostname,
+		"app":        workload.App,
+		"instanceId": workload.InstanceID,
+		"ipAddr":     workload.Address,
+		"status":     statusUp,
+		"port":       map[string]interface{}{"$": workload.Port, "@enabled": strconv.FormatBool(true)},
+		"metadata":   md,
+	}
+}
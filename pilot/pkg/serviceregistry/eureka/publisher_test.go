@@ -0,0 +1,165 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	networking "istio.io/api/networking/v1alpha3"
+	"istio.io/istio/pilot/pkg/model"
+)
+
+// fakeEurekaServer records every registration/heartbeat/deregistration call a Publisher makes,
+// keyed by method+path, so tests can assert the expected sequence happened without standing up
+// a real Eureka server.
+type fakeEurekaServer struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeEurekaServer) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeEurekaServer) callCount(method, path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c == method+" "+path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPublisherRegisterHeartbeatDeregister(t *testing.T) {
+	fake := &fakeEurekaServer{}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	publisher := NewPublisher(server.URL + "/eureka")
+	workload := PublishedWorkload{
+		App:        "reviews",
+		InstanceID: "reviews-1",
+		Hostname:   "reviews-1.reviews.svc.cluster.local",
+		Address:    "10.0.0.1",
+		Port:       9080,
+	}
+
+	registerPath := fmt.Sprintf("/eureka/apps/%s", workload.App)
+	instancePath := fmt.Sprintf("/eureka/apps/%s/%s", workload.App, workload.InstanceID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := publisher.Publish(ctx, workload); err != nil {
+		t.Fatalf("Publish() returned error: %v", err)
+	}
+	if got := fake.callCount(http.MethodPost, registerPath); got != 1 {
+		t.Errorf("registration calls = %d, want 1", got)
+	}
+
+	// heartbeatLoop only renews on renewalInterval's ticker, which is too long to wait out in a
+	// unit test; exercise the same PUT path it uses directly instead of sleeping 30s.
+	if err := publisher.renew(workload); err != nil {
+		t.Fatalf("renew() returned error: %v", err)
+	}
+	if got := fake.callCount(http.MethodPut, instancePath); got != 1 {
+		t.Errorf("heartbeat calls = %d, want 1", got)
+	}
+
+	if err := publisher.Unpublish(workload); err != nil {
+		t.Fatalf("Unpublish() returned error: %v", err)
+	}
+	if got := fake.callCount(http.MethodDelete, instancePath); got != 1 {
+		t.Errorf("deregistration calls = %d, want 1", got)
+	}
+
+	// Unpublish must have cancelled the heartbeat loop, so no further renewals are sent.
+	time.Sleep(10 * time.Millisecond)
+	if got := fake.callCount(http.MethodPut, instancePath); got != 1 {
+		t.Errorf("heartbeat calls after Unpublish = %d, want still 1 (loop should have stopped)", got)
+	}
+}
+
+func TestPublisherCloseDeregistersTrackedWorkloads(t *testing.T) {
+	fake := &fakeEurekaServer{}
+	server := httptest.NewServer(fake.handler())
+	defer server.Close()
+
+	publisher := NewPublisher(server.URL + "/eureka")
+	workload := PublishedWorkload{App: "ratings", InstanceID: "ratings-1", Address: "10.0.0.2", Port: 9080}
+
+	if err := publisher.Publish(context.Background(), workload); err != nil {
+		t.Fatalf("Publish() returned error: %v", err)
+	}
+
+	if err := publisher.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	instancePath := fmt.Sprintf("/eureka/apps/%s/%s", workload.App, workload.InstanceID)
+	if got := fake.callCount(http.MethodDelete, instancePath); got != 1 {
+		t.Errorf("deregistration calls after Close = %d, want 1", got)
+	}
+}
+
+// TestWorkloadFromConfig covers the WorkloadEntry -> PublishedWorkload translation
+// WatchWorkloadEntries' event handler relies on, including the two rejection cases: a config
+// whose Spec isn't a WorkloadEntry at all, and a WorkloadEntry with no ports to register.
+func TestWorkloadFromConfig(t *testing.T) {
+	good := model.Config{
+		ConfigMeta: model.ConfigMeta{Name: "ratings-v1", Namespace: "default"},
+		Spec: &networking.WorkloadEntry{
+			Address: "10.0.0.2",
+			Ports:   map[string]uint32{"http": 9080},
+			Labels:  map[string]string{"version": "v1"},
+		},
+	}
+	workload, ok := workloadFromConfig(good)
+	if !ok {
+		t.Fatalf("workloadFromConfig(%+v) ok = false, want true", good)
+	}
+	if workload.App != "ratings-v1" || workload.Address != "10.0.0.2" || workload.Port != 9080 {
+		t.Errorf("workloadFromConfig(%+v) = %+v, want App=ratings-v1 Address=10.0.0.2 Port=9080", good, workload)
+	}
+	if workload.InstanceID != workloadKey("default", "ratings-v1") {
+		t.Errorf("workloadFromConfig(%+v).InstanceID = %q, want %q", good, workload.InstanceID, workloadKey("default", "ratings-v1"))
+	}
+
+	if _, ok := workloadFromConfig(model.Config{Spec: &networking.ServiceEntry{}}); ok {
+		t.Error("workloadFromConfig with a non-WorkloadEntry Spec: ok = true, want false")
+	}
+
+	noPorts := model.Config{
+		ConfigMeta: model.ConfigMeta{Name: "ratings-v1", Namespace: "default"},
+		Spec:       &networking.WorkloadEntry{Address: "10.0.0.2"},
+	}
+	if _, ok := workloadFromConfig(noPorts); ok {
+		t.Error("workloadFromConfig with no ports: ok = true, want false")
+	}
+}